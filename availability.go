@@ -0,0 +1,110 @@
+package mux_monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// availabilitySlots is the number of slots the availability window is divided into
+const availabilitySlots = 10
+
+// AvailabilityMinWindow is the smallest window accepted by WithRouteAvailability, so that the gauge is updated at
+// most every AvailabilityMinWindow / 10
+const AvailabilityMinWindow = time.Second
+
+// routeWindow holds the success and error counts of a route for each slot of the window
+type routeWindow struct {
+	successes [availabilitySlots]uint64
+	errors    [availabilitySlots]uint64
+}
+
+// routeAvailability keeps rolling success and error counts per route
+type routeAvailability struct {
+	mu      sync.Mutex
+	gauge   *prometheus.GaugeVec
+	routes  map[string]*routeWindow
+	current int
+
+	// stop ends the update goroutine, which closes done when it exits
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newRouteAvailability(gauge *prometheus.GaugeVec) *routeAvailability {
+	return &routeAvailability{
+		gauge:  gauge,
+		routes: make(map[string]*routeWindow),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// record counts a request to addr in the current slot
+func (a *routeAvailability) record(addr string, isError bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	window, ok := a.routes[addr]
+	if !ok {
+		window = &routeWindow{}
+		a.routes[addr] = window
+	}
+
+	if isError {
+		window.errors[a.current]++
+	} else {
+		window.successes[a.current]++
+	}
+}
+
+// update sets the availability gauge of each route from its counts over the window and advances to the next slot.
+// Routes without requests in the window keep their last availability.
+func (a *routeAvailability) update() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for addr, window := range a.routes {
+		var successes, failures uint64
+		for i := 0; i < availabilitySlots; i++ {
+			successes += window.successes[i]
+			failures += window.errors[i]
+		}
+
+		if total := successes + failures; total > 0 {
+			a.gauge.WithLabelValues(addr).Set(float64(successes) / float64(total))
+		}
+	}
+
+	a.current = (a.current + 1) % availabilitySlots
+	for _, window := range a.routes {
+		window.successes[a.current] = 0
+		window.errors[a.current] = 0
+	}
+}
+
+// run updates the availability gauge each time a slot of the window elapses, until close is called
+func (a *routeAvailability) run(window time.Duration) {
+	ticker := time.NewTicker(window / availabilitySlots)
+	go func() {
+		defer close(a.done)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.stop:
+				return
+			case <-ticker.C:
+				a.update()
+			}
+		}
+	}()
+}
+
+// close stops the updates of the availability gauge, waiting for the update goroutine to exit
+func (a *routeAvailability) close() {
+	a.stopOnce.Do(func() { close(a.stop) })
+	<-a.done
+}
@@ -0,0 +1,70 @@
+package mux_monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRouteAvailability(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithRouteAvailability(time.Hour))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	for _, id := range []string{"1", "2", "3", "broken"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/"+id, nil))
+	}
+	monitor.routeAvailability.update()
+
+	if availability := testutil.ToFloat64(monitor.routeAvailability.gauge.WithLabelValues("/items/{id}")); availability != 0.75 {
+		t.Errorf("expected availability 0.75, got %v", availability)
+	}
+}
+
+func TestRouteAvailabilityWindowExpires(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithRouteAvailability(time.Hour))
+	availability := monitor.routeAvailability
+
+	availability.record("/route", true)
+	availability.update()
+	for i := 0; i < availabilitySlots-1; i++ {
+		availability.update()
+	}
+	availability.record("/route", false)
+	availability.update()
+
+	if value := testutil.ToFloat64(availability.gauge.WithLabelValues("/route")); value != 1 {
+		t.Errorf("expected the error to leave the window and availability to be 1, got %v", value)
+	}
+}
+
+func TestWithRouteAvailabilityInvalidWindow(t *testing.T) {
+	for _, window := range []time.Duration{0, AvailabilityMinWindow - 1, time.Nanosecond} {
+		if _, err := New("v1.0.0", DefaultErrorMessageKey, DefaultBuckets, WithRouteAvailability(window)); err == nil {
+			t.Errorf("expected an error for a window of %v", window)
+		}
+	}
+}
+
+func TestRouteAvailabilityClose(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithRouteAvailability(AvailabilityMinWindow))
+
+	monitor.Close()
+	monitor.Close()
+
+	select {
+	case <-monitor.routeAvailability.done:
+	default:
+		t.Error("expected the update goroutine to exit on Close")
+	}
+}
@@ -0,0 +1,108 @@
+package mux_monitor
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TCPChecker is a DependencyChecker that reports UP when a TCP connection to its address can be established
+type TCPChecker struct {
+	name    string
+	address string
+	timeout time.Duration
+}
+
+// NewTCPChecker creates a DependencyChecker that dials address over TCP and reports UP when the connection succeeds within timeout
+func NewTCPChecker(name, address string, timeout time.Duration) DependencyChecker {
+	return &TCPChecker{name: name, address: address, timeout: timeout}
+}
+
+// GetDependencyName returns the name of the dependency
+func (c *TCPChecker) GetDependencyName() string {
+	return c.name
+}
+
+// Check dials the dependency address and reports whether the connection succeeded
+func (c *TCPChecker) Check() DependencyStatus {
+	return c.CheckContext(context.Background())
+}
+
+// CheckContext dials the dependency address, giving up when ctx is done or the timeout expires
+func (c *TCPChecker) CheckContext(ctx context.Context) DependencyStatus {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return DOWN
+	}
+	_ = conn.Close()
+
+	return UP
+}
+
+// HTTPChecker is an HTTPDependencyChecker that sends a GET request to its URL and reports UP when the response
+// status code is not an error
+type HTTPChecker struct {
+	name    string
+	url     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// NewHTTPChecker creates a DependencyChecker that sends a GET request to url and reports UP when a response with a
+// status code that is not an error is received within timeout
+func NewHTTPChecker(name, url string, timeout time.Duration) DependencyChecker {
+	return &HTTPChecker{name: name, url: url, timeout: timeout, client: http.DefaultClient}
+}
+
+// GetDependencyName returns the name of the dependency
+func (c *HTTPChecker) GetDependencyName() string {
+	return c.name
+}
+
+// Check requests the dependency URL and reports whether it responded successfully
+func (c *HTTPChecker) Check() DependencyStatus {
+	return c.CheckContext(context.Background())
+}
+
+// CheckContext requests the dependency URL and reports whether it responded successfully, giving up when ctx is
+// done or the timeout expires
+func (c *HTTPChecker) CheckContext(ctx context.Context) DependencyStatus {
+	statusCode, err := c.CheckHTTP(ctx)
+	if err != nil || IsStatusError(statusCode) {
+		return DOWN
+	}
+	return UP
+}
+
+// CheckHTTP requests the dependency URL, returning the response status code, giving up when ctx is done or the
+// timeout expires
+func (c *HTTPChecker) CheckHTTP(ctx context.Context) (int, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,91 @@
+package mux_monitor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTCPCheckerUp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	checker := NewTCPChecker("tcp-dependency", listener.Addr().String(), time.Second)
+
+	if name := checker.GetDependencyName(); name != "tcp-dependency" {
+		t.Errorf("expected name tcp-dependency, got %s", name)
+	}
+	if status := checker.Check(); status != UP {
+		t.Errorf("expected UP, got %v", status)
+	}
+}
+
+func TestTCPCheckerDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	checker := NewTCPChecker("tcp-dependency", address, time.Second)
+
+	if status := checker.Check(); status != DOWN {
+		t.Errorf("expected DOWN, got %v", status)
+	}
+}
+
+func TestTCPCheckerCanceledContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	checker := NewTCPChecker("tcp-dependency", listener.Addr().String(), time.Second).(*TCPChecker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if status := checker.CheckContext(ctx); status != DOWN {
+		t.Errorf("expected DOWN for a canceled context, got %v", status)
+	}
+}
+
+func TestHTTPChecker(t *testing.T) {
+	for path, expected := range map[string]DependencyStatus{
+		"/health":  UP,
+		"/missing": DOWN,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/health" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		checker := NewHTTPChecker("http-dependency", server.URL+path, time.Second)
+		if status := checker.Check(); status != expected {
+			t.Errorf("expected %v for %s, got %v", expected, path, status)
+		}
+		server.Close()
+	}
+}
+
+func TestHTTPCheckerUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	server.Close()
+
+	checker := NewHTTPChecker("http-dependency", server.URL, time.Second).(*HTTPChecker)
+	if _, err := checker.CheckHTTP(context.Background()); err == nil {
+		t.Error("expected an error requesting a closed server")
+	}
+	if status := checker.Check(); status != DOWN {
+		t.Errorf("expected DOWN, got %v", status)
+	}
+}
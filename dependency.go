@@ -0,0 +1,441 @@
+package mux_monitor
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DependencyStatus is the type to represent UP or DOWN states
+type DependencyStatus int
+
+// DependencyChecker specifies the methods a checker must implement.
+type DependencyChecker interface {
+	GetDependencyName() string
+	Check() DependencyStatus
+}
+
+// ContextDependencyChecker is implemented by checkers that can abort a check when its context is done.
+// The monitor calls CheckContext instead of Check for such checkers.
+type ContextDependencyChecker interface {
+	DependencyChecker
+	CheckContext(ctx context.Context) DependencyStatus
+}
+
+// TypedDependencyChecker is implemented by checkers declaring the type of their dependency (e.g. sql or http),
+// recorded as the type label of the dependency_check_duration_seconds histogram
+type TypedDependencyChecker interface {
+	DependencyChecker
+	Type() string
+}
+
+// HTTPDependencyChecker is implemented by checkers whose check is an HTTP request. The monitor calls CheckHTTP
+// instead of Check for such checkers, recording the request on dependency_request_seconds with its status code.
+// The dependency is UP when a response is received with a status code that is not an error.
+type HTTPDependencyChecker interface {
+	DependencyChecker
+	CheckHTTP(ctx context.Context) (statusCode int, err error)
+}
+
+// HTTPDependencyType is the type label of HTTPDependencyChecker checkers not implementing TypedDependencyChecker
+const HTTPDependencyType = "http"
+
+// UnknownDependencyType is the type label of checkers not implementing TypedDependencyChecker
+const UnknownDependencyType = "unknown"
+
+const (
+	DOWN DependencyStatus = iota
+	UP
+)
+
+// String returns UP or DOWN, the status label value of the checks recorded on dependency_request_seconds
+func (s DependencyStatus) String() string {
+	if s == UP {
+		return "UP"
+	}
+	return "DOWN"
+}
+
+// GaugeValue returns the value of the status on the dependency_up gauge: 1 for UP and 0 otherwise, regardless of the
+// values of the constants
+func (s DependencyStatus) GaugeValue() float64 {
+	switch s {
+	case UP:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// dependencyCheck is a checker registered in the monitor
+type dependencyCheck struct {
+	checker DependencyChecker
+	period  time.Duration
+	// cancel stops the check goroutine, which closes done when it exits
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// mutex guards the debouncing state below
+	mutex       sync.Mutex
+	reported    DependencyStatus
+	hasReported bool
+	consecutive int
+}
+
+// newDependencyCheck creates a check of the checker every period, stopped by cancel
+func newDependencyCheck(checker DependencyChecker, period time.Duration, cancel context.CancelFunc) *dependencyCheck {
+	return &dependencyCheck{checker: checker, period: period, cancel: cancel, done: make(chan struct{})}
+}
+
+// debounce returns the status to report for a check result: the previously reported status until the opposite one
+// is seen in failures consecutive checks for DOWN, or successes consecutive checks for UP
+func (c *dependencyCheck) debounce(status DependencyStatus, failures, successes int) DependencyStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.hasReported || status == c.reported {
+		c.reported, c.hasReported, c.consecutive = status, true, 0
+		return status
+	}
+
+	c.consecutive++
+	threshold := successes
+	if status != UP {
+		threshold = failures
+	}
+	if c.consecutive >= threshold {
+		c.reported, c.consecutive = status, 0
+	}
+	return c.reported
+}
+
+// dependencyWaitInterval is the interval between the checks of WaitForDependencies
+var dependencyWaitInterval = time.Second
+
+// CollectDependencyTime collet the duration of dependency requests in seconds
+func (m *Monitor) CollectDependencyTime(name, reqType, status, method, addr, isError, errorMessage string, durationSeconds float64) {
+	if m.noop {
+		return
+	}
+	m.collectDependencyRequest(context.Background(), []string{name, reqType, status, method, addr, isError, m.errorMessageLabel(errorMessage)},
+		time.Duration(durationSeconds*float64(time.Second)))
+}
+
+// AddDependencyChecker creates a ticker that periodically executes the checker and collects the dependency state metrics
+func (m *Monitor) AddDependencyChecker(checker DependencyChecker, checkingPeriod time.Duration) {
+	m.AddDependencyCheckerContext(context.Background(), checker, checkingPeriod)
+}
+
+// AddDependencyCheckerContext creates a ticker that periodically executes the checker and collects the dependency
+// state metrics until ctx is done. Checkers sharing a dependency name overwrite each other's metrics, use
+// RegisterDependencyChecker to reject them. It panics when the checking period isn't positive, like time.NewTicker.
+func (m *Monitor) AddDependencyCheckerContext(ctx context.Context, checker DependencyChecker, checkingPeriod time.Duration) {
+	if checkingPeriod <= 0 {
+		panic(fmt.Sprintf("non-positive checking period %v for dependency checker %q", checkingPeriod, checker.GetDependencyName()))
+	}
+	if m.noop {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	check := newDependencyCheck(checker, checkingPeriod, cancel)
+
+	m.checkersMutex.Lock()
+	m.checkers = append(m.checkers, check)
+	m.checkersMutex.Unlock()
+
+	go m.runDependencyCheck(ctx, check)
+}
+
+// RegisterDependencyChecker is like AddDependencyCheckerContext, but returns an error instead of adding the checker
+// when a checker of a dependency with the same name is already registered, since both would record the same series,
+// or when the checking period isn't positive
+func (m *Monitor) RegisterDependencyChecker(ctx context.Context, checker DependencyChecker, checkingPeriod time.Duration) error {
+	if checkingPeriod <= 0 {
+		return fmt.Errorf("dependency checker %q checking period must be positive, got %v", checker.GetDependencyName(), checkingPeriod)
+	}
+	if m.noop {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	check := newDependencyCheck(checker, checkingPeriod, cancel)
+
+	m.checkersMutex.Lock()
+	for _, registered := range m.checkers {
+		if registered.checker.GetDependencyName() == checker.GetDependencyName() {
+			m.checkersMutex.Unlock()
+			cancel()
+			return fmt.Errorf("dependency checker %q already registered", checker.GetDependencyName())
+		}
+	}
+	m.checkers = append(m.checkers, check)
+	m.checkersMutex.Unlock()
+
+	go m.runDependencyCheck(ctx, check)
+	return nil
+}
+
+// runDependencyCheck executes the check every period, shifted by the jitter, until ctx is done, then unregisters it
+func (m *Monitor) runDependencyCheck(ctx context.Context, check *dependencyCheck) {
+	defer close(check.done)
+	defer m.removeDependencyCheck(check)
+
+	next := time.Now().Add(m.jitteredPeriod(check.period))
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			m.checkDebounced(ctx, check)
+			next = next.Add(m.jitteredPeriod(check.period))
+			if now := time.Now(); next.Before(now) {
+				// the check outlasted the period, skip the missed checks like a time.Ticker
+				next = now.Add(m.jitteredPeriod(check.period))
+			}
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// jitteredPeriod returns the period randomly shifted by up to the dependency check jitter fraction, in either
+// direction so checks keep the configured period on average
+func (m *Monitor) jitteredPeriod(period time.Duration) time.Duration {
+	if m.dependencyCheckJitter == 0 {
+		return period
+	}
+	return period + time.Duration(float64(period)*m.dependencyCheckJitter*(2*rand.Float64()-1))
+}
+
+// removeDependencyCheck unregisters the check from the monitor
+func (m *Monitor) removeDependencyCheck(check *dependencyCheck) {
+	m.checkersMutex.Lock()
+	defer m.checkersMutex.Unlock()
+
+	for i, registered := range m.checkers {
+		if registered == check {
+			m.checkers = append(m.checkers[:i], m.checkers[i+1:]...)
+			return
+		}
+	}
+}
+
+// RemoveDependencyChecker stops the checkers of the named dependency, waiting for their goroutines to exit, and
+// deletes the series of the dependency, including its dependency_request_seconds ones. It reports whether a checker
+// was registered for it.
+func (m *Monitor) RemoveDependencyChecker(name string) bool {
+	m.checkersMutex.Lock()
+	var checks []*dependencyCheck
+	for _, check := range m.checkers {
+		if check.checker.GetDependencyName() == name {
+			checks = append(checks, check)
+		}
+	}
+	m.checkersMutex.Unlock()
+
+	for _, check := range checks {
+		check.cancel()
+		<-check.done
+	}
+
+	m.removeDependency(name)
+
+	m.statusesMutex.Lock()
+	delete(m.dependencyStatuses, name)
+	m.statusesMutex.Unlock()
+
+	return len(checks) > 0
+}
+
+// Close stops the background goroutines of the monitor, waiting for them to exit: the route availability updates and
+// the dependency checkers. The metrics stay registered.
+func (m *Monitor) Close() {
+	if m.routeAvailability != nil {
+		m.routeAvailability.close()
+	}
+
+	m.checkersMutex.Lock()
+	checks := append([]*dependencyCheck(nil), m.checkers...)
+	m.checkersMutex.Unlock()
+
+	for _, check := range checks {
+		check.cancel()
+		<-check.done
+	}
+}
+
+// WaitForDependencies runs all dependency checkers until every dependency is UP, returning an error listing the
+// dependencies still down if ctx is done first. It allows services to block startup until their dependencies are available.
+func (m *Monitor) WaitForDependencies(ctx context.Context) error {
+	ticker := time.NewTicker(dependencyWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		down := m.checkAll(ctx)
+		if len(down) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("dependencies still down: %s: %w", strings.Join(down, ", "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// execute executes the checker, collecting the duration and timestamp of the check and counting failed checks. The
+// check is also recorded on dependency_request_seconds, successful or not.
+func (m *Monitor) execute(ctx context.Context, checker DependencyChecker) DependencyStatus {
+	started := m.now()
+
+	var status DependencyStatus
+	httpChecker, isHTTP := checker.(HTTPDependencyChecker)
+	if isHTTP {
+		status = m.checkHTTP(ctx, httpChecker)
+	} else if contextChecker, ok := checker.(ContextDependencyChecker); ok {
+		status = contextChecker.CheckContext(ctx)
+	} else {
+		status = checker.Check()
+	}
+
+	finished := m.now()
+	if !isHTTP {
+		// HTTP checks record their request with its status code instead
+		m.collectDependencyRequest(ctx, []string{checker.GetDependencyName(), dependencyType(checker), status.String(), "", "",
+			strconv.FormatBool(status != UP), ""}, finished.Sub(started))
+	}
+	m.dependencyLastCheck.WithLabelValues(checker.GetDependencyName()).Set(timestampSeconds(finished))
+	m.dependencyCheckTime.WithLabelValues(checker.GetDependencyName(), dependencyType(checker)).Observe(finished.Sub(started).Seconds())
+	if status != UP {
+		m.dependencyFailures.WithLabelValues(checker.GetDependencyName()).Inc()
+	}
+	return status
+}
+
+// check executes the checker and collects the dependency state metrics
+func (m *Monitor) check(ctx context.Context, checker DependencyChecker) DependencyStatus {
+	status := m.execute(ctx, checker)
+	m.reportStatus(ctx, checker.GetDependencyName(), status)
+	return status
+}
+
+// checkDebounced executes a registered check and collects the dependency state metrics, reporting a status change
+// only after the consecutive checks required by WithDependencyCheckThresholds
+func (m *Monitor) checkDebounced(ctx context.Context, check *dependencyCheck) DependencyStatus {
+	status := check.debounce(m.execute(ctx, check.checker), m.failureThreshold, m.successThreshold)
+	m.reportStatus(ctx, check.checker.GetDependencyName(), status)
+	return status
+}
+
+// reportStatus records the status of a dependency, calling the dependency state change hook once the status is
+// stored and no lock is held anymore
+func (m *Monitor) reportStatus(ctx context.Context, name string, status DependencyStatus) {
+	m.collectDependencyStatus(ctx, name, status)
+	previous, ok := m.storeDependencyStatus(name, status)
+	if ok && previous != status && m.dependencyStateChangeHook != nil {
+		m.dependencyStateChangeHook(name, previous, status)
+	}
+}
+
+// SetDependencyStatus records the status of a dependency pushed by the application, e.g. from a health callback or
+// event stream, without registering a checker. It updates dependency_up, dependency_last_check_timestamp_seconds
+// and the status returned by DependencyStatus like a check would.
+func (m *Monitor) SetDependencyStatus(name string, status DependencyStatus) {
+	if m.noop {
+		return
+	}
+	m.dependencyLastCheck.WithLabelValues(name).Set(timestampSeconds(m.now()))
+	m.reportStatus(context.Background(), name, status)
+}
+
+// storeDependencyStatus records the last known status of a dependency, returning the previous one and whether there
+// was one
+func (m *Monitor) storeDependencyStatus(name string, status DependencyStatus) (DependencyStatus, bool) {
+	m.statusesMutex.Lock()
+	defer m.statusesMutex.Unlock()
+
+	if m.dependencyStatuses == nil {
+		m.dependencyStatuses = make(map[string]DependencyStatus)
+	}
+	previous, ok := m.dependencyStatuses[name]
+	m.dependencyStatuses[name] = status
+	return previous, ok
+}
+
+// DependencyStatus returns the status reported by the last check of a dependency, and false when it wasn't checked yet
+func (m *Monitor) DependencyStatus(name string) (DependencyStatus, bool) {
+	m.statusesMutex.RLock()
+	defer m.statusesMutex.RUnlock()
+
+	status, ok := m.dependencyStatuses[name]
+	return status, ok
+}
+
+// AllDependencyStatuses returns a copy of the statuses reported by the last check of each checked dependency
+func (m *Monitor) AllDependencyStatuses() map[string]DependencyStatus {
+	m.statusesMutex.RLock()
+	defer m.statusesMutex.RUnlock()
+
+	statuses := make(map[string]DependencyStatus, len(m.dependencyStatuses))
+	for name, status := range m.dependencyStatuses {
+		statuses[name] = status
+	}
+	return statuses
+}
+
+// checkHTTP executes the HTTP checker, recording its request on dependency_request_seconds
+func (m *Monitor) checkHTTP(ctx context.Context, checker HTTPDependencyChecker) DependencyStatus {
+	started := m.now()
+	statusCode, err := checker.CheckHTTP(ctx)
+	duration := m.now().Sub(started)
+
+	status, isError, errorMessage := UP, m.IsStatusError(statusCode), ""
+	if err != nil {
+		isError, errorMessage = true, err.Error()
+	}
+	if isError {
+		status = DOWN
+	}
+
+	m.collectDependencyRequest(ctx, []string{checker.GetDependencyName(), dependencyType(checker), strconv.Itoa(statusCode), "", "",
+		strconv.FormatBool(isError), m.errorMessageLabel(errorMessage)}, duration)
+	return status
+}
+
+// checkAll executes every registered checker, returning the sorted names of the dependencies that are DOWN
+func (m *Monitor) checkAll(ctx context.Context) []string {
+	m.checkersMutex.Lock()
+	checks := make([]*dependencyCheck, len(m.checkers))
+	copy(checks, m.checkers)
+	m.checkersMutex.Unlock()
+
+	var down []string
+	for _, check := range checks {
+		if m.checkDebounced(ctx, check) != UP {
+			down = append(down, check.checker.GetDependencyName())
+		}
+	}
+	sort.Strings(down)
+
+	return down
+}
+
+// dependencyType returns the type declared by the checker, or UnknownDependencyType
+func dependencyType(checker DependencyChecker) string {
+	if typedChecker, ok := checker.(TypedDependencyChecker); ok {
+		return typedChecker.Type()
+	}
+	if _, ok := checker.(HTTPDependencyChecker); ok {
+		return HTTPDependencyType
+	}
+	return UnknownDependencyType
+}
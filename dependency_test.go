@@ -0,0 +1,668 @@
+package mux_monitor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/mux-monitor/muxmonitortest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// constantChecker is a DependencyChecker always reporting the same status
+type constantChecker struct {
+	name   string
+	status DependencyStatus
+}
+
+func (c *constantChecker) GetDependencyName() string {
+	return c.name
+}
+
+func (c *constantChecker) Check() DependencyStatus {
+	return c.status
+}
+
+// recoveringChecker is a DependencyChecker reporting DOWN until it has been checked a number of times
+type recoveringChecker struct {
+	name       string
+	downChecks int64
+	checks     int64
+}
+
+func (c *recoveringChecker) GetDependencyName() string {
+	return c.name
+}
+
+func (c *recoveringChecker) Check() DependencyStatus {
+	if atomic.AddInt64(&c.checks, 1) > c.downChecks {
+		return UP
+	}
+	return DOWN
+}
+
+func TestWaitForDependencies(t *testing.T) {
+	defer func(interval time.Duration) { dependencyWaitInterval = interval }(dependencyWaitInterval)
+	dependencyWaitInterval = time.Millisecond
+
+	monitor, _ := newTestMonitor(t)
+	checker := &recoveringChecker{name: "database", downChecks: 3}
+	monitor.AddDependencyChecker(checker, time.Hour)
+	monitor.AddDependencyChecker(&constantChecker{name: "cache", status: UP}, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := monitor.WaitForDependencies(ctx); err != nil {
+		t.Fatalf("expected dependencies to be up, got %v", err)
+	}
+	if checks := atomic.LoadInt64(&checker.checks); checks != 4 {
+		t.Errorf("expected 4 checks until the dependency is up, got %d", checks)
+	}
+}
+
+func TestWaitForDependenciesTimeout(t *testing.T) {
+	defer func(interval time.Duration) { dependencyWaitInterval = interval }(dependencyWaitInterval)
+	dependencyWaitInterval = time.Millisecond
+
+	monitor, _ := newTestMonitor(t)
+	monitor.AddDependencyChecker(&constantChecker{name: "database", status: DOWN}, time.Hour)
+	monitor.AddDependencyChecker(&constantChecker{name: "cache", status: UP}, time.Hour)
+	monitor.AddDependencyChecker(&constantChecker{name: "broker", status: DOWN}, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	err := monitor.WaitForDependencies(ctx)
+	if err == nil {
+		t.Fatal("expected an error when dependencies stay down")
+	}
+	if !strings.Contains(err.Error(), "broker, database") || strings.Contains(err.Error(), "cache") {
+		t.Errorf("expected the error to list only the dependencies down, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the error to wrap the context error, got %v", err)
+	}
+}
+
+// countingChecker is a DependencyChecker counting how many times it was checked
+type countingChecker struct {
+	name   string
+	checks int64
+}
+
+func (c *countingChecker) GetDependencyName() string {
+	return c.name
+}
+
+func (c *countingChecker) Check() DependencyStatus {
+	atomic.AddInt64(&c.checks, 1)
+	return UP
+}
+
+// contextChecker is a ContextDependencyChecker reporting DOWN once its context is done
+type contextChecker struct {
+	constantChecker
+}
+
+func (c *contextChecker) CheckContext(ctx context.Context) DependencyStatus {
+	if ctx.Err() != nil {
+		return DOWN
+	}
+	return c.status
+}
+
+func TestAddDependencyCheckerContextCancel(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	checker := &countingChecker{name: "database"}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	check := newDependencyCheck(checker, time.Millisecond, cancel)
+	monitor.checkers = append(monitor.checkers, check)
+
+	done := make(chan struct{})
+	go func() {
+		monitor.runDependencyCheck(ctx, check)
+		close(done)
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the checker goroutine to terminate after the context is canceled")
+	}
+
+	checks := atomic.LoadInt64(&checker.checks)
+	if checks == 0 {
+		t.Error("expected the checker to run before the context is canceled")
+	}
+	time.Sleep(time.Millisecond * 10)
+	if after := atomic.LoadInt64(&checker.checks); after != checks {
+		t.Errorf("expected no checks after the context is canceled, got %d more", after-checks)
+	}
+	if len(monitor.checkers) != 0 {
+		t.Errorf("expected the checker to be unregistered, got %d checkers", len(monitor.checkers))
+	}
+}
+
+func TestAddDependencyCheckerContextChecker(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	checker := &contextChecker{constantChecker{name: "database", status: UP}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if status := monitor.check(ctx, checker); status != UP {
+		t.Errorf("expected UP, got %v", status)
+	}
+
+	cancel()
+	if status := monitor.check(ctx, checker); status != DOWN {
+		t.Errorf("expected the checker to receive the canceled context, got %v", status)
+	}
+}
+
+// typedChecker is a TypedDependencyChecker of the sql type
+type typedChecker struct {
+	constantChecker
+}
+
+func (c *typedChecker) Type() string {
+	return "sql"
+}
+
+func TestDependencyCheckDuration(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	monitor.check(context.Background(), &typedChecker{constantChecker{name: "database", status: UP}})
+	monitor.check(context.Background(), &constantChecker{name: "cache", status: UP})
+
+	if count := sampleCount(t, monitor.dependencyCheckTime, "database", "sql"); count != 1 {
+		t.Errorf("expected 1 check observation with the declared type, got %d", count)
+	}
+	if count := sampleCount(t, monitor.dependencyCheckTime, "cache", UnknownDependencyType); count != 1 {
+		t.Errorf("expected 1 check observation with the unknown type, got %d", count)
+	}
+}
+
+// slowChecker is a DependencyChecker taking a delay to report its status
+type slowChecker struct {
+	constantChecker
+	delay time.Duration
+}
+
+func (c *slowChecker) Check() DependencyStatus {
+	time.Sleep(c.delay)
+	return c.status
+}
+
+func TestDependencyCheckRequestDuration(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	monitor.check(context.Background(), &slowChecker{constantChecker{name: "database", status: DOWN}, time.Millisecond * 20})
+
+	var metric dto.Metric
+	observer := monitor.dependencyReqDuration.WithLabelValues("database", UnknownDependencyType, "DOWN", "", "", "true", "")
+	if err := observer.(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	if count, sum := metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum(); count != 1 || sum < 0.02 {
+		t.Errorf("expected the failed check to be recorded as a 20ms error, got %d observations summing %vs", count, sum)
+	}
+}
+
+func TestDependencyCheckFailures(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	checker := &scriptedChecker{constantChecker: constantChecker{name: "database"}, statuses: []DependencyStatus{
+		DOWN, UP, DOWN, DOWN, UP,
+	}}
+
+	for i := 0; i < len(checker.statuses); i++ {
+		monitor.check(context.Background(), checker)
+	}
+
+	metrics := muxmonitortest.CollectMatching(t, monitor.dependencyFailures, prometheus.Labels{"name": "database"})
+	if len(metrics) != 1 || metrics[0].GetCounter().GetValue() != 3 {
+		t.Errorf("expected 3 failed checks to be counted, got %v", metrics)
+	}
+}
+
+func TestSetDependencyStatus(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	monitor.SetDependencyStatus("queue", UP)
+	if up, ok := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "queue"}); !ok || up != 1 {
+		t.Errorf("expected dependency_up 1 after pushing UP, got %v", up)
+	}
+
+	monitor.SetDependencyStatus("queue", DOWN)
+	if up, _ := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "queue"}); up != 0 {
+		t.Errorf("expected dependency_up 0 after pushing DOWN, got %v", up)
+	}
+	if status, ok := monitor.DependencyStatus("queue"); !ok || status != DOWN {
+		t.Errorf("expected the pushed status to be kept, got %v", status)
+	}
+	if metrics := muxmonitortest.CollectMatching(t, monitor.dependencyLastCheck, prometheus.Labels{"name": "queue"}); len(metrics) != 1 {
+		t.Error("expected the last check timestamp to be set")
+	}
+}
+
+func TestWithDependencyStateChangeHook(t *testing.T) {
+	type change struct {
+		name              string
+		previous, current DependencyStatus
+	}
+	var changes []change
+	var monitor *Monitor
+	monitor, _ = newTestMonitor(t, WithDependencyStateChangeHook(func(name string, previous, current DependencyStatus) {
+		// the hook may call back into the monitor
+		if status, _ := monitor.DependencyStatus(name); status != current {
+			t.Errorf("expected the new status to be stored before the hook is called, got %v", status)
+		}
+		changes = append(changes, change{name, previous, current})
+	}))
+
+	checker := &scriptedChecker{constantChecker: constantChecker{name: "database"}, statuses: []DependencyStatus{UP, UP, DOWN, DOWN}}
+	for i := 0; i < len(checker.statuses); i++ {
+		monitor.check(context.Background(), checker)
+	}
+
+	if len(changes) != 1 || changes[0] != (change{"database", UP, DOWN}) {
+		t.Errorf("expected a single change from UP to DOWN, got %v", changes)
+	}
+}
+
+func TestWithDependencyStateChangeHookNil(t *testing.T) {
+	if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithDependencyStateChangeHook(nil)); err == nil {
+		t.Error("expected an error for a nil hook")
+	}
+}
+
+func TestDependencyStatusGaugeValue(t *testing.T) {
+	for status, expected := range map[DependencyStatus]float64{
+		UP:                   1,
+		DOWN:                 0,
+		DependencyStatus(-1): 0,
+		DependencyStatus(42): 0,
+	} {
+		if value := status.GaugeValue(); value != expected {
+			t.Errorf("expected status %d to be reported as %v, got %v", status, expected, value)
+		}
+	}
+
+	monitor, _ := newTestMonitor(t)
+	monitor.check(context.Background(), &constantChecker{name: "database", status: UP})
+	monitor.check(context.Background(), &constantChecker{name: "cache", status: DOWN})
+	if up, _ := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "database"}); up != 1 {
+		t.Errorf("expected dependency_up 1 for an UP dependency, got %v", up)
+	}
+	if up, _ := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "cache"}); up != 0 {
+		t.Errorf("expected dependency_up 0 for a DOWN dependency, got %v", up)
+	}
+}
+
+func TestDependencyLastCheckTimestamp(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	checker := &countingChecker{name: "database"}
+
+	if metrics := muxmonitortest.CollectMatching(t, monitor.dependencyLastCheck, prometheus.Labels{"name": "database"}); len(metrics) != 0 {
+		t.Fatal("expected no timestamp before the first check")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor.AddDependencyCheckerContext(ctx, checker, time.Millisecond*10)
+
+	lastCheck := func() float64 {
+		metrics := muxmonitortest.CollectMatching(t, monitor.dependencyLastCheck, prometheus.Labels{"name": "database"})
+		if len(metrics) == 0 {
+			return 0
+		}
+		return metrics[0].GetGauge().GetValue()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for lastCheck() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	first := lastCheck()
+	if first < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Fatalf("expected a recent timestamp after the first tick, got %v", first)
+	}
+
+	for lastCheck() == first && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if second := lastCheck(); second <= first {
+		t.Errorf("expected the timestamp to advance after the next tick, got %v then %v", first, second)
+	}
+}
+
+// timingChecker is a DependencyChecker signaling when it's checked
+type timingChecker struct {
+	constantChecker
+	checks chan time.Time
+}
+
+func (c *timingChecker) Check() DependencyStatus {
+	select {
+	case c.checks <- time.Now():
+	default:
+	}
+	return UP
+}
+
+func TestWithDependencyCheckJitter(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithDependencyCheckJitter(0.5))
+	checker := &timingChecker{constantChecker: constantChecker{name: "database"}, checks: make(chan time.Time, 10)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor.AddDependencyCheckerContext(ctx, checker, time.Millisecond)
+
+	// the jittered periods are checked by TestJitteredPeriod, this only checks the checks keep being scheduled
+	deadline := time.After(5 * time.Second)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-checker.checks:
+		case <-deadline:
+			t.Fatalf("expected 3 jittered checks, got %d", i)
+		}
+	}
+}
+
+func TestJitteredPeriod(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithDependencyCheckJitter(0.2))
+	const period = time.Second
+
+	var sum time.Duration
+	const samples = 1000
+	for i := 0; i < samples; i++ {
+		jittered := monitor.jitteredPeriod(period)
+		if jittered < 800*time.Millisecond || jittered > 1200*time.Millisecond {
+			t.Fatalf("expected the jittered period to be within 20%% of %v, got %v", period, jittered)
+		}
+		sum += jittered
+	}
+	if mean := sum / samples; mean < 950*time.Millisecond || mean > 1050*time.Millisecond {
+		t.Errorf("expected the jittered period to average %v, got %v", period, mean)
+	}
+}
+
+func TestWithDependencyCheckJitterInvalid(t *testing.T) {
+	for _, fraction := range []float64{-0.1, 1, 2} {
+		if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithDependencyCheckJitter(fraction)); err == nil {
+			t.Errorf("expected an error for jitter %v", fraction)
+		}
+	}
+}
+
+func TestHTTPDependencyChecker(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	statusCode := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+	checker := NewHTTPChecker("api", server.URL, time.Second)
+
+	monitor.check(context.Background(), checker)
+	if up, _ := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "api"}); up != float64(UP) {
+		t.Errorf("expected the dependency to be UP, got %v", up)
+	}
+
+	statusCode = http.StatusServiceUnavailable
+	monitor.check(context.Background(), checker)
+	if up, _ := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "api"}); up != float64(DOWN) {
+		t.Errorf("expected the dependency to be DOWN, got %v", up)
+	}
+
+	for status, isError := range map[string]string{"200": "false", "503": "true"} {
+		labels := prometheus.Labels{"name": "api", "type": HTTPDependencyType, "status": status, "isError": isError}
+		if count := muxmonitortest.SampleCount(t, monitor.dependencyReqDuration, labels); count != 1 {
+			t.Errorf("expected 1 dependency request with status %s, got %d", status, count)
+		}
+	}
+	if count := sampleCount(t, monitor.dependencyCheckTime, "api", HTTPDependencyType); count != 2 {
+		t.Errorf("expected 2 check observations with the http type, got %d", count)
+	}
+}
+
+func TestRegisterDependencyCheckerDuplicate(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := monitor.RegisterDependencyChecker(ctx, &constantChecker{name: "database", status: UP}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := monitor.RegisterDependencyChecker(ctx, &constantChecker{name: "database", status: DOWN}, time.Hour); err == nil {
+		t.Error("expected an error registering a second checker of the database dependency")
+	}
+	if err := monitor.RegisterDependencyChecker(ctx, &constantChecker{name: "cache", status: UP}, time.Hour); err != nil {
+		t.Errorf("expected a checker of another dependency to be registered, got %v", err)
+	}
+
+	monitor.checkersMutex.Lock()
+	defer monitor.checkersMutex.Unlock()
+	if len(monitor.checkers) != 2 {
+		t.Errorf("expected 2 registered checkers, got %d", len(monitor.checkers))
+	}
+}
+
+func TestDependencyCheckerNonPositivePeriod(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	for _, period := range []time.Duration{0, -time.Second} {
+		if err := monitor.RegisterDependencyChecker(context.Background(), &constantChecker{name: "database", status: UP}, period); err == nil {
+			t.Errorf("expected an error registering a checker with a period of %v", period)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic adding a checker with a period of %v", period)
+				}
+			}()
+			monitor.AddDependencyChecker(&constantChecker{name: "cache", status: UP}, period)
+		}()
+	}
+
+	monitor.checkersMutex.Lock()
+	defer monitor.checkersMutex.Unlock()
+	if len(monitor.checkers) != 0 {
+		t.Errorf("expected no registered checker, got %d", len(monitor.checkers))
+	}
+}
+
+func TestRegisterDependencyCheckerAfterCancel(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if err := monitor.RegisterDependencyChecker(ctx, &constantChecker{name: "database", status: UP}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := monitor.RegisterDependencyChecker(context.Background(), &constantChecker{name: "database", status: UP}, time.Hour)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the name to be released once the first checker stopped, got %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDependencyStatus(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, ok := monitor.DependencyStatus("database"); ok {
+		t.Error("expected no status before the first check")
+	}
+
+	monitor.AddDependencyCheckerContext(ctx, &constantChecker{name: "database", status: UP}, time.Millisecond)
+	monitor.AddDependencyCheckerContext(ctx, &constantChecker{name: "cache", status: DOWN}, time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for len(monitor.AllDependencyStatuses()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected both dependencies to be checked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if status, ok := monitor.DependencyStatus("database"); !ok || status != UP {
+		t.Errorf("expected the database to be UP, got %v", status)
+	}
+	statuses := monitor.AllDependencyStatuses()
+	if statuses["database"] != UP || statuses["cache"] != DOWN {
+		t.Errorf("expected the database UP and the cache DOWN, got %v", statuses)
+	}
+}
+
+// scriptedChecker is a DependencyChecker reporting a sequence of statuses, one per check
+type scriptedChecker struct {
+	constantChecker
+	statuses []DependencyStatus
+	checks   int
+}
+
+func (c *scriptedChecker) Check() DependencyStatus {
+	status := c.statuses[c.checks%len(c.statuses)]
+	c.checks++
+	return status
+}
+
+func TestWithDependencyCheckThresholds(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithDependencyCheckThresholds(3, 2))
+	checker := &scriptedChecker{constantChecker: constantChecker{name: "database"}, statuses: []DependencyStatus{
+		UP,
+		DOWN, UP, DOWN, UP, // alternating blips
+		DOWN, DOWN, DOWN, // 3 consecutive failures
+		UP, DOWN, UP, UP, // 2 consecutive successes after a blip
+	}}
+	check := &dependencyCheck{checker: checker}
+
+	for i, expected := range []DependencyStatus{
+		UP,
+		UP, UP, UP, UP,
+		UP, UP, DOWN,
+		DOWN, DOWN, DOWN, UP,
+	} {
+		monitor.checkDebounced(context.Background(), check)
+		if up, _ := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "database"}); up != expected.GaugeValue() {
+			t.Fatalf("expected dependency_up %v after check %d, got %v", expected, i+1, up)
+		}
+	}
+}
+
+func TestDependencyCheckDefaultThresholds(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	checker := &scriptedChecker{constantChecker: constantChecker{name: "database"}, statuses: []DependencyStatus{UP, DOWN, UP}}
+	check := &dependencyCheck{checker: checker}
+
+	for i, expected := range []DependencyStatus{UP, DOWN, UP} {
+		if status := monitor.checkDebounced(context.Background(), check); status != expected {
+			t.Errorf("expected every check to be reported without thresholds, got %v after check %d", status, i+1)
+		}
+	}
+}
+
+func TestWithDependencyCheckThresholdsInvalid(t *testing.T) {
+	for _, thresholds := range [][2]int{{0, 1}, {1, 0}, {-1, 2}} {
+		if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithDependencyCheckThresholds(thresholds[0], thresholds[1])); err == nil {
+			t.Errorf("expected an error for thresholds %v", thresholds)
+		}
+	}
+}
+
+func TestRemoveDependencyChecker(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	checker := &countingChecker{name: "plugin"}
+	monitor.AddDependencyChecker(checker, time.Millisecond)
+	monitor.AddDependencyChecker(&constantChecker{name: "database", status: UP}, time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for len(monitor.AllDependencyStatuses()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected both dependencies to be checked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	monitor.checkersMutex.Lock()
+	var check *dependencyCheck
+	for _, registered := range monitor.checkers {
+		if registered.checker == checker {
+			check = registered
+		}
+	}
+	monitor.checkersMutex.Unlock()
+
+	if !monitor.RemoveDependencyChecker("plugin") {
+		t.Fatal("expected the plugin checker to be removed")
+	}
+
+	select {
+	case <-check.done:
+	default:
+		t.Error("expected the checker goroutine to have exited")
+	}
+	checks := atomic.LoadInt64(&checker.checks)
+	time.Sleep(10 * time.Millisecond)
+	if after := atomic.LoadInt64(&checker.checks); after != checks {
+		t.Errorf("expected no checks after the removal, got %d more", after-checks)
+	}
+
+	if _, ok := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "plugin"}); ok {
+		t.Error("expected the dependency_up series of the plugin to be deleted")
+	}
+	for name, metric := range map[string]prometheus.Collector{
+		"dependency_request_seconds":        monitor.dependencyReqDuration,
+		"dependency_check_duration_seconds": monitor.dependencyCheckTime,
+		"dependency_check_failures_total":   monitor.dependencyFailures,
+	} {
+		if metrics := muxmonitortest.CollectMatching(t, metric, prometheus.Labels{"name": "plugin"}); len(metrics) != 0 {
+			t.Errorf("expected the %s series of the plugin to be deleted, got %d", name, len(metrics))
+		}
+	}
+	if _, ok := monitor.DependencyStatus("plugin"); ok {
+		t.Error("expected the status of the plugin to be deleted")
+	}
+	if _, ok := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "database"}); !ok {
+		t.Error("expected the other dependency to keep its series")
+	}
+	if monitor.RemoveDependencyChecker("plugin") {
+		t.Error("expected no checker left to remove")
+	}
+}
+
+func TestCloseStopsDependencyCheckers(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	checker := &countingChecker{name: "plugin"}
+	monitor.AddDependencyChecker(checker, time.Millisecond)
+
+	monitor.Close()
+
+	checks := atomic.LoadInt64(&checker.checks)
+	time.Sleep(10 * time.Millisecond)
+	if after := atomic.LoadInt64(&checker.checks); after != checks {
+		t.Errorf("expected no checks after Close, got %d more", after-checks)
+	}
+	if monitor.RemoveDependencyChecker("plugin") {
+		t.Error("expected no checker left after Close")
+	}
+}
@@ -26,7 +26,7 @@ func YourHandler(w http.ResponseWriter, _ *http.Request) {
 
 func main() {
 	// Creates mux-monitor instance
-	monitor, err := muxMonitor.New("v1.0.0", muxMonitor.DefaultErrorMessageKey, muxMonitor.DefaultBuckets)
+	monitor, err := muxMonitor.NewMonitor("v1.0.0")
 	if err != nil {
 		panic(err)
 	}
@@ -4,5 +4,7 @@ go 1.13
 
 require (
 	github.com/gorilla/mux v1.7.4
-	github.com/prometheus/client_golang v1.5.1
+	github.com/prometheus/client_golang v1.15.1
+	github.com/prometheus/client_model v0.3.0
+	github.com/prometheus/common v0.42.0
 )
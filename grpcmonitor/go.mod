@@ -0,0 +1,27 @@
+module github.com/labbsr0x/mux-monitor/grpcmonitor
+
+go 1.19
+
+require (
+	github.com/labbsr0x/mux-monitor v0.0.0-00010101000000-000000000000
+	github.com/prometheus/client_golang v1.15.1
+	google.golang.org/grpc v1.56.3
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/gorilla/mux v1.7.4 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.42.0 // indirect
+	github.com/prometheus/procfs v0.9.0 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+)
+
+replace github.com/labbsr0x/mux-monitor => ../
@@ -0,0 +1,69 @@
+// Package grpcmonitor records the gRPC requests served by an application on the grpc_request_seconds histogram of a
+// mux-monitor Monitor, sharing its registry, application_info and dependency metrics with the HTTP ones:
+//
+//	monitor, err := muxMonitor.NewMonitor("v1.0.0")
+//	interceptors, err := grpcmonitor.New(monitor)
+//	server := grpc.NewServer(
+//		grpc.UnaryInterceptor(interceptors.UnaryServerInterceptor),
+//		grpc.StreamInterceptor(interceptors.StreamServerInterceptor),
+//	)
+package grpcmonitor
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	muxMonitor "github.com/labbsr0x/mux-monitor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Interceptors are gRPC server interceptors recording the RPCs on a monitor
+type Interceptors struct {
+	monitor *muxMonitor.Monitor
+}
+
+// New registers the grpc_request_seconds histogram of the monitor, returning the interceptors recording on it
+func New(monitor *muxMonitor.Monitor) (*Interceptors, error) {
+	if err := monitor.RegisterGRPCMetrics(); err != nil {
+		return nil, err
+	}
+	return &Interceptors{monitor: monitor}, nil
+}
+
+// UnaryServerInterceptor is a grpc.UnaryServerInterceptor recording the duration of unary RPCs, measured with the
+// monitor clock and labeled with the name of their status code
+func (i *Interceptors) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	started := i.monitor.Now()
+	resp, err := handler(ctx, req)
+	i.collect(info.FullMethod, err, i.monitor.Now().Sub(started))
+	return resp, err
+}
+
+// StreamServerInterceptor is a grpc.StreamServerInterceptor recording the duration of streaming RPCs, from their
+// start to the return of their handler, labeled with the name of their status code
+func (i *Interceptors) StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	started := i.monitor.Now()
+	err := handler(srv, ss)
+	i.collect(info.FullMethod, err, i.monitor.Now().Sub(started))
+	return err
+}
+
+// collect records an RPC on the monitor. Every status code other than OK is an error.
+func (i *Interceptors) collect(fullMethod string, err error, duration time.Duration) {
+	service, method := splitMethod(fullMethod)
+	code := status.Code(err)
+	i.monitor.CollectGRPCTime(service, method, code.String(), code != codes.OK, duration)
+}
+
+// splitMethod returns the service and method of a full method name, e.g. helloworld.Greeter and SayHello for
+// /helloworld.Greeter/SayHello
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
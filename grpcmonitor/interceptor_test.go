@@ -0,0 +1,175 @@
+package grpcmonitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	muxMonitor "github.com/labbsr0x/mux-monitor"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// requestLabels returns the labels and sample count of each grpc_request_seconds series gathered from the registry
+func requestLabels(t *testing.T, registry *prometheus.Registry) []map[string]string {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var series []map[string]string
+	for _, family := range families {
+		if family.GetName() != "grpc_request_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if metric.GetHistogram().GetSampleCount() == 1 {
+				series = append(series, labels)
+			}
+		}
+	}
+	return series
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptors, err := New(monitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/users.UserService/GetUser"}
+	_, err = interceptors.UnaryServerInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected the handler error to be returned, got %v", err)
+	}
+
+	series := requestLabels(t, registry)
+	if len(series) != 1 {
+		t.Fatalf("expected 1 recorded request, got %v", series)
+	}
+	for name, expected := range map[string]string{"service": "users.UserService", "method": "GetUser", "status": "NotFound", "isError": "true"} {
+		if series[0][name] != expected {
+			t.Errorf("expected the %s label to be %q, got %q", name, expected, series[0][name])
+		}
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptors, err := New(monitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/users.UserService/ListUsers", IsServerStream: true}
+	if err := interceptors.StreamServerInterceptor(nil, nil, info, func(interface{}, grpc.ServerStream) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	series := requestLabels(t, registry)
+	if len(series) != 1 || series[0]["method"] != "ListUsers" || series[0]["status"] != "OK" || series[0]["isError"] != "false" {
+		t.Errorf("expected 1 successful ListUsers request, got %v", series)
+	}
+}
+
+func TestInterceptorsUseMonitorClock(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	registry := prometheus.NewRegistry()
+	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRegistry(registry), muxMonitor.WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptors, err := New(monitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/users.UserService/GetUser"}
+	_, _ = interceptors.UnaryServerInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		now = now.Add(250 * time.Millisecond)
+		return nil, nil
+	})
+	streamInfo := &grpc.StreamServerInfo{FullMethod: "/users.UserService/ListUsers"}
+	_ = interceptors.StreamServerInterceptor(nil, nil, streamInfo, func(interface{}, grpc.ServerStream) error {
+		now = now.Add(time.Second)
+		return nil
+	})
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "grpc_request_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "method" {
+					sums[label.GetValue()] = metric.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+	if sums["GetUser"] != 0.25 || sums["ListUsers"] != 1 {
+		t.Errorf("expected the RPCs to be observed taking exactly 0.25s and 1s, got %v", sums)
+	}
+}
+
+func TestNewRegistersOnce(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := New(monitor); err != nil {
+			t.Fatalf("expected interceptors to be built again from the monitor, got %v", err)
+		}
+	}
+}
+
+func TestNewRegistrationConflict(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "grpc_request_seconds", Help: "Conflicting metric"}))
+
+	if _, err := New(monitor); err == nil {
+		t.Error("expected an error for a conflicting metric")
+	}
+}
+
+func TestSplitMethod(t *testing.T) {
+	for fullMethod, expected := range map[string][2]string{
+		"/helloworld.Greeter/SayHello": {"helloworld.Greeter", "SayHello"},
+		"SayHello":                     {"unknown", "SayHello"},
+	} {
+		if service, method := splitMethod(fullMethod); service != expected[0] || method != expected[1] {
+			t.Errorf("expected %q to be split into %v, got %q and %q", fullMethod, expected, service, method)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package mux_monitor
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labelCache caches the series of the request metrics resolved for each combination of label values, so recording
+// a request skips hashing the label values on the metric vectors
+type labelCache struct {
+	durations         sync.Map
+	overflowDurations sync.Map
+	sizes             sync.Map
+
+	// hits and misses count the lookups finding a cached series or resolving a new one
+	hits   prometheus.Counter
+	misses prometheus.Counter
+}
+
+// labelCacheKey returns the key of a combination of label values, separated by a byte that's not valid UTF-8
+func labelCacheKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+// observer returns the series of a histogram vector for the label values, resolving it on the first call
+func (c *labelCache) observer(cache *sync.Map, histogram *prometheus.HistogramVec, key string, labelValues []string) prometheus.Observer {
+	if observer, ok := cache.Load(key); ok {
+		c.hits.Inc()
+		return observer.(prometheus.Observer)
+	}
+	c.misses.Inc()
+	observer, _ := cache.LoadOrStore(key, histogram.WithLabelValues(labelValues...))
+	return observer.(prometheus.Observer)
+}
+
+// counter returns the series of a counter vector for the label values, resolving it on the first call
+func (c *labelCache) counter(cache *sync.Map, counter *prometheus.CounterVec, key string, labelValues []string) prometheus.Counter {
+	if series, ok := cache.Load(key); ok {
+		c.hits.Inc()
+		return series.(prometheus.Counter)
+	}
+	c.misses.Inc()
+	series, _ := cache.LoadOrStore(key, counter.WithLabelValues(labelValues...))
+	return series.(prometheus.Counter)
+}
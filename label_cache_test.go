@@ -0,0 +1,87 @@
+package mux_monitor
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/mux-monitor/muxmonitortest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWithLabelCache(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithLabelCache(), WithOverflowHistogram(time.Second, []float64{5, 10}))
+	labelValues := []string{"HTTP/1.1", "200", "GET", "/users", "false", ""}
+
+	for i := 0; i < 3; i++ {
+		monitor.collectTime(labelValues, "", time.Millisecond)
+		monitor.collectSize(labelValues, 10)
+	}
+	monitor.collectTime(labelValues, "", 2*time.Second)
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/users"}); count != 3 {
+		t.Errorf("expected 3 requests recorded through the cached series, got %d", count)
+	}
+	if count := sampleCount(t, monitor.reqOverflowDuration, labelValues...); count != 1 {
+		t.Errorf("expected the slow request recorded on the overflow histogram, got %d", count)
+	}
+	if size := muxmonitortest.CounterSum(t, monitor.respSize, prometheus.Labels{"addr": "/users"}); size != 30 {
+		t.Errorf("expected 30 bytes recorded through the cached series, got %v", size)
+	}
+}
+
+func TestLabelCacheHitsAndMisses(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithLabelCache())
+
+	monitor.collectTime([]string{"HTTP/1.1", "200", "GET", "/users", "false", ""}, "", time.Millisecond)
+	monitor.collectTime([]string{"HTTP/1.1", "200", "GET", "/users", "false", ""}, "", time.Millisecond)
+	monitor.collectTime([]string{"HTTP/1.1", "200", "GET", "/orders", "false", ""}, "", time.Millisecond)
+
+	if hits := testutil.ToFloat64(monitor.labelCache.hits); hits != 1 {
+		t.Errorf("expected 1 label cache hit, got %v", hits)
+	}
+	if misses := testutil.ToFloat64(monitor.labelCache.misses); misses != 2 {
+		t.Errorf("expected 2 label cache misses, got %v", misses)
+	}
+}
+
+func TestLabelCacheMetricsDisabledByDefault(t *testing.T) {
+	_, registry := newTestMonitor(t)
+	if output := scrape(t, registry, false); strings.Contains(output, "label_cache") {
+		t.Errorf("expected no label cache metrics without the label cache:\n%s", output)
+	}
+}
+
+func benchmarkCollect(b *testing.B, opts ...Option) {
+	monitor, err := NewMonitor("v1.0.0", append(opts, WithRegistry(prometheus.NewRegistry()))...)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	const routes = 1000
+	labelValues := make([][]string, routes)
+	for i := range labelValues {
+		labelValues[i] = []string{"HTTP/1.1", "200", "GET", "/route/" + strconv.Itoa(i), "false", ""}
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			values := labelValues[i%routes]
+			monitor.collectTime(values, "", time.Millisecond)
+			monitor.collectSize(values, 100)
+			i++
+		}
+	})
+}
+
+func BenchmarkCollect(b *testing.B) {
+	benchmarkCollect(b)
+}
+
+func BenchmarkCollectWithLabelCache(b *testing.B) {
+	benchmarkCollect(b, WithLabelCache())
+}
@@ -0,0 +1,368 @@
+package mux_monitor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultErrorMessageMaxLength is the default maximum number of characters of the errorMessage label
+const DefaultErrorMessageMaxLength = 200
+
+// QueryParamMaxLength is the maximum number of characters of the values of query parameter labels
+const QueryParamMaxLength = 64
+
+// ExtraLabelMaxLength is the maximum number of characters of the values of extra labels
+const ExtraLabelMaxLength = 64
+
+// ExtraLabelMaxValues is the maximum number of distinct values of each extra label, later values are folded into
+// OtherLabelValue
+const ExtraLabelMaxValues = 100
+
+// OtherLabelValue is the value of extra and query parameter labels whose distinct values exceeded ExtraLabelMaxValues
+const OtherLabelValue = "other"
+
+// UnknownContentType is the content_type label value of responses without a Content-Type header
+const UnknownContentType = "unknown"
+
+// UnmatchedAddr is the addr label value of requests that matched no route or pattern, e.g. those answered with
+// 404 Not Found, so that arbitrary request paths can't increase the cardinality of the addr label
+const UnmatchedAddr = "unmatched"
+
+// OtherMethod is the method label value of requests with a method outside KnownMethods, when they're folded
+const OtherMethod = "OTHER"
+
+// PreflightMethod is the method label value of CORS preflight requests, when they're folded
+const PreflightMethod = "PREFLIGHT"
+
+// KnownMethods are the request methods kept as method label values when unknown methods are folded
+var KnownMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodDelete,
+	http.MethodPatch,
+	http.MethodHead,
+	http.MethodOptions,
+}
+
+// observation holds what the middleware measured about a request
+type observation struct {
+	request      *http.Request
+	method       string
+	statusCode   int
+	addr         string
+	isError      bool
+	errorMessage string
+	contentType  string
+	extraLabels  []string
+}
+
+// requestLabel is a label of the request metrics along with how its value is taken from an observation
+type requestLabel struct {
+	name  string
+	value func(o *observation) string
+}
+
+// buildRequestLabels returns the labels of the request metrics enabled by the monitor settings
+func (m *Monitor) buildRequestLabels() []requestLabel {
+	labels := []requestLabel{
+		{name: m.labelName("type"), value: func(o *observation) string { return m.typeLabel(o.request) }},
+		{name: m.labelName("status"), value: func(o *observation) string { return strconv.Itoa(o.statusCode) }},
+	}
+
+	if !m.methodLabelDisabled {
+		labels = append(labels, requestLabel{name: m.labelName("method"), value: func(o *observation) string { return o.method }})
+	}
+
+	labels = append(labels,
+		requestLabel{name: m.labelName("addr"), value: func(o *observation) string { return o.addr }},
+		requestLabel{name: m.labelName("isError"), value: func(o *observation) string { return strconv.FormatBool(o.isError) }},
+	)
+
+	if !m.errorMessageLabelDisabled {
+		labels = append(labels, requestLabel{name: m.labelName("errorMessage"), value: func(o *observation) string { return o.errorMessage }})
+	}
+
+	if m.pathVarsLabelEnabled {
+		labels = append(labels, requestLabel{name: m.labelName("path_vars"), value: func(o *observation) string {
+			return strconv.Itoa(len(mux.Vars(o.request)))
+		}})
+	}
+
+	if m.schemeLabelEnabled {
+		labels = append(labels, requestLabel{name: m.labelName("scheme"), value: func(o *observation) string {
+			if o.request.TLS != nil {
+				return "https"
+			}
+			return "http"
+		}})
+	}
+
+	if m.retryHeader != "" {
+		labels = append(labels, requestLabel{name: m.labelName("retry"), value: func(o *observation) string {
+			return strconv.FormatBool(m.retried(o.request))
+		}})
+	}
+
+	if m.contentTypeLabelEnabled {
+		labels = append(labels, requestLabel{name: m.labelName("content_type"), value: func(o *observation) string { return o.contentType }})
+	}
+
+	for i, param := range m.queryParamLabels {
+		param, limit := param, m.queryParamLimits[i]
+		labels = append(labels, requestLabel{name: param, value: func(o *observation) string {
+			return limit.bound(queryParamLabel(o.request.URL.Query().Get(param)))
+		}})
+	}
+
+	for i, name := range m.extraLabelNames {
+		i := i
+		labels = append(labels, requestLabel{name: name, value: func(o *observation) string { return o.extraLabels[i] }})
+	}
+
+	return labels
+}
+
+// typeLabel returns the type label value of a request: its protocol, or the value returned by the function set by
+// WithTypeLabel, bounded like extra labels
+func (m *Monitor) typeLabel(r *http.Request) string {
+	if m.requestType == nil {
+		return r.Proto
+	}
+	return m.requestTypeLimit.bound(truncate(strings.ToValidUTF8(m.requestType(r), "\uFFFD"), ExtraLabelMaxLength))
+}
+
+// extraLabels returns the values of the extra labels of a request, bounded by length and number of distinct values.
+// Missing values are empty and surplus values are ignored.
+func (m *Monitor) extraLabels(r *http.Request) []string {
+	if len(m.extraLabelNames) == 0 {
+		return nil
+	}
+
+	values := m.extraLabelValues(r)
+	labels := make([]string, len(m.extraLabelNames))
+	for i := range labels {
+		if i < len(values) {
+			labels[i] = m.extraLabelLimits[i].bound(truncate(strings.ToValidUTF8(values[i], "\uFFFD"), ExtraLabelMaxLength))
+		}
+	}
+	return labels
+}
+
+// labelValueLimit bounds the number of distinct values of a label
+type labelValueLimit struct {
+	mutex  sync.RWMutex
+	values map[string]bool
+	max    int
+}
+
+// newLabelValueLimit creates a limit of max distinct values
+func newLabelValueLimit(max int) *labelValueLimit {
+	return &labelValueLimit{values: make(map[string]bool), max: max}
+}
+
+// bound returns the value when it was already seen or the limit isn't reached yet, and OtherLabelValue otherwise
+func (l *labelValueLimit) bound(value string) string {
+	l.mutex.RLock()
+	seen, full := l.values[value], len(l.values) >= l.max
+	l.mutex.RUnlock()
+	if seen {
+		return value
+	}
+	if full {
+		return OtherLabelValue
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.values[value] && len(l.values) >= l.max {
+		return OtherLabelValue
+	}
+	l.values[value] = true
+	return value
+}
+
+// routeLabelNames returns the label names of the per-route counters and histograms other than the request metrics:
+// method, unless removed by WithoutMethodLabel, and addr, followed by the given names
+func (m *Monitor) routeLabelNames(names ...string) []string {
+	labelNames := []string{m.labelName("addr")}
+	if !m.methodLabelDisabled {
+		labelNames = []string{m.labelName("method"), m.labelName("addr")}
+	}
+	return append(labelNames, names...)
+}
+
+// routeLabelValues returns the label values of an observation matching routeLabelNames
+func (m *Monitor) routeLabelValues(o *observation, values ...string) []string {
+	labelValues := []string{o.addr}
+	if !m.methodLabelDisabled {
+		labelValues = []string{o.method, o.addr}
+	}
+	return append(labelValues, values...)
+}
+
+// retried reports whether a request is a retry: it carries the retry header and is accepted by the retry predicate
+func (m *Monitor) retried(r *http.Request) bool {
+	if r.Header.Get(m.retryHeader) == "" {
+		return false
+	}
+	return m.isRetry == nil || m.isRetry(r)
+}
+
+// defaultRequestLabelNames are the names of every request metrics label before renaming
+var defaultRequestLabelNames = []string{"type", "status", "method", "addr", "isError", "errorMessage", "path_vars", "scheme", "retry", "content_type"}
+
+// isDefaultRequestLabelName reports whether name is the default name of a request metrics label
+func isDefaultRequestLabelName(name string) bool {
+	for _, defaultName := range defaultRequestLabelNames {
+		if name == defaultName {
+			return true
+		}
+	}
+	return false
+}
+
+// labelName returns the name of a request metrics label, renamed when set by WithLabelNames
+func (m *Monitor) labelName(name string) string {
+	if renamed, ok := m.labelNames[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// validateRequestLabels checks that no two request metrics labels share a name after renaming
+func (m *Monitor) validateRequestLabels() error {
+	seen := make(map[string]bool, len(m.requestLabels))
+	for name := range m.constLabels {
+		seen[name] = true
+	}
+	for _, label := range m.requestLabels {
+		if seen[label.name] {
+			return fmt.Errorf("duplicate request label name %q", label.name)
+		}
+		seen[label.name] = true
+	}
+	return nil
+}
+
+// requestLabelNames returns the names of the request metrics labels
+func (m *Monitor) requestLabelNames() []string {
+	names := make([]string, len(m.requestLabels))
+	for i, label := range m.requestLabels {
+		names[i] = label.name
+	}
+	return names
+}
+
+// requestLabelValues returns the values of the request metrics labels for an observation
+func (m *Monitor) requestLabelValues(o *observation) []string {
+	values := make([]string, len(m.requestLabels))
+	for i, label := range m.requestLabels {
+		values[i] = label.value(o)
+	}
+	return values
+}
+
+// methodLabel returns the method label value of a request, folding CORS preflight requests into PreflightMethod and
+// unknown methods into OtherMethod when enabled
+func (m *Monitor) methodLabel(r *http.Request) string {
+	method := r.Method
+	if m.preflightFolded && isPreflight(r) {
+		return PreflightMethod
+	}
+	if !m.unknownMethodsFolded {
+		return method
+	}
+	for _, known := range KnownMethods {
+		if method == known {
+			return method
+		}
+	}
+	return OtherMethod
+}
+
+// errorMessageLabel returns the errorMessage label value of an error message, empty when the label is disabled
+func (m *Monitor) errorMessageLabel(errorMessage string) string {
+	if m.errorMessageLabelDisabled {
+		return ""
+	}
+	return m.boundErrorMessage(errorMessage)
+}
+
+// boundErrorMessage sanitizes, flattens and truncates an error message to bound its cardinality
+func (m *Monitor) boundErrorMessage(errorMessage string) string {
+	if m.errorMessageSanitizer != nil {
+		errorMessage = m.errorMessageSanitizer(errorMessage)
+	}
+	return truncate(singleLine(errorMessage), m.errorMessageMaxLength)
+}
+
+// singleLine replaces each run of newlines and other control characters by a space, and invalid UTF-8 by the
+// replacement character, so multiline error messages become well-formed single-line label values
+func singleLine(s string) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+
+	var b strings.Builder
+	control := false
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			control = true
+			continue
+		}
+		if control && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		control = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// queryParamLabel returns the label value of a query parameter value, replacing invalid UTF-8 and truncating it
+// to QueryParamMaxLength characters
+func queryParamLabel(value string) string {
+	return truncate(strings.ToValidUTF8(value, "\uFFFD"), QueryParamMaxLength)
+}
+
+// contentTypeLabel returns the content_type label value of a Content-Type header: its lowercase media type without
+// parameters, or UnknownContentType when it's empty
+func contentTypeLabel(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	if contentType == "" {
+		return UnknownContentType
+	}
+	return truncate(strings.ToValidUTF8(contentType, "\uFFFD"), ExtraLabelMaxLength)
+}
+
+// isPreflight reports whether the request is a CORS preflight request: an OPTIONS request with the
+// Access-Control-Request-Method header
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// truncate returns the first maxLength characters of s, or s itself when maxLength isn't positive
+func truncate(s string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(s) <= maxLength {
+		return s
+	}
+
+	runes := 0
+	for i := range s {
+		if runes == maxLength {
+			return s[:i]
+		}
+		runes++
+	}
+	return s
+}
@@ -0,0 +1,529 @@
+package mux_monitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/mux-monitor/muxmonitortest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// serveError serves a request to a route failing with the given error message
+func serveError(monitor *Monitor, errorMessage string) {
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/failing", func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(DefaultErrorMessageKey, errorMessage)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/failing", nil))
+}
+
+// requestSecondsLabels returns the labels of the first request_seconds series gathered from the monitor
+func requestSecondsLabels(t *testing.T, monitor *Monitor) map[string]string {
+	t.Helper()
+
+	metrics := make(chan prometheus.Metric, 1)
+	go func() {
+		monitor.reqDuration.Collect(metrics)
+		close(metrics)
+	}()
+
+	labels := make(map[string]string)
+	for metric := range metrics {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		for _, pair := range m.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+	}
+	return labels
+}
+
+func TestErrorMessageLabelTruncated(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	serveError(monitor, strings.Repeat("x", 10*1024))
+
+	errorMessage, ok := requestSecondsLabels(t, monitor)["errorMessage"]
+	if !ok {
+		t.Fatal("expected the errorMessage label")
+	}
+	if length := utf8.RuneCountInString(errorMessage); length != DefaultErrorMessageMaxLength {
+		t.Errorf("expected the errorMessage label to be truncated to %d characters, got %d", DefaultErrorMessageMaxLength, length)
+	}
+}
+
+func TestWithErrorMessageMaxLength(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithErrorMessageMaxLength(5))
+	serveError(monitor, "ação inválida")
+
+	if errorMessage := requestSecondsLabels(t, monitor)["errorMessage"]; errorMessage != "ação " {
+		t.Errorf("expected the errorMessage label to be truncated to 5 characters, got %q", errorMessage)
+	}
+}
+
+func TestWithErrorMessageSanitizer(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithErrorMessageSanitizer(func(errorMessage string) string {
+		return strings.SplitN(errorMessage, ":", 2)[0]
+	}))
+	serveError(monitor, "user not found: id 4b7e1f")
+
+	if errorMessage := requestSecondsLabels(t, monitor)["errorMessage"]; errorMessage != "user not found" {
+		t.Errorf("expected the sanitized errorMessage label, got %q", errorMessage)
+	}
+}
+
+func TestWithoutErrorMessageLabel(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithoutErrorMessageLabel())
+	serveError(monitor, "internal server error")
+
+	labels := requestSecondsLabels(t, monitor)
+	if _, ok := labels["errorMessage"]; ok {
+		t.Errorf("expected no errorMessage label, got %v", labels)
+	}
+	if labels["isError"] != "true" {
+		t.Errorf("expected the remaining labels to be recorded, got %v", labels)
+	}
+}
+
+func TestWithPathVarsLabel(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithPathVarsLabel())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {})
+	r.HandleFunc("/users/{user}/items/{item}", func(w http.ResponseWriter, _ *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1/items/2", nil))
+
+	output := scrape(t, registry, false)
+	for _, expected := range []string{
+		`request_seconds_count{addr="/health",errorMessage="",isError="false",method="GET",path_vars="0",status="200",type="HTTP/1.1"} 1`,
+		`request_seconds_count{addr="/users/{user}/items/{item}",errorMessage="",isError="false",method="GET",path_vars="2",status="200",type="HTTP/1.1"} 1`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestWithSchemeLabel(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithSchemeLabel())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users", func(w http.ResponseWriter, _ *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "https://example.com/users", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/users", nil))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"scheme": "https"}); count != 1 {
+		t.Errorf("expected 1 request over TLS, got %d", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"scheme": "http"}); count != 1 {
+		t.Errorf("expected 1 plaintext request, got %d", count)
+	}
+}
+
+func TestWithTypeLabel(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithTypeLabel(func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			return "api"
+		}
+		return "static"
+	}))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/api/users", func(w http.ResponseWriter, _ *http.Request) {})
+	r.HandleFunc("/index.html", func(w http.ResponseWriter, _ *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/index.html", nil))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/api/users", "type": "api"}); count != 1 {
+		t.Errorf("expected 1 api request, got %d", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/index.html", "type": "static"}); count != 1 {
+		t.Errorf("expected 1 static request, got %d", count)
+	}
+}
+
+func TestWithTypeLabelNil(t *testing.T) {
+	if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithTypeLabel(nil)); err == nil {
+		t.Error("expected an error for a nil request type function")
+	}
+}
+
+func TestWithContentTypeLabel(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithContentTypeLabel())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte("[]"))
+	})
+	r.HandleFunc("/empty", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/empty", nil))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/users", "content_type": "application/json"}); count != 1 {
+		t.Errorf("expected 1 request with the application/json content type, got %d", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/empty", "content_type": UnknownContentType}); count != 1 {
+		t.Errorf("expected 1 request with an unknown content type, got %d", count)
+	}
+}
+
+func TestContentTypeLabel(t *testing.T) {
+	for contentType, expected := range map[string]string{
+		"application/json; charset=utf-8": "application/json",
+		"Text/HTML":                       "text/html",
+		" image/png ":                     "image/png",
+		"":                                UnknownContentType,
+		"; charset=utf-8":                 UnknownContentType,
+	} {
+		if label := contentTypeLabel(contentType); label != expected {
+			t.Errorf("expected %q to become %q, got %q", contentType, expected, label)
+		}
+	}
+}
+
+func TestWithRetryLabel(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithRetryLabel("Idempotency-Key", func(r *http.Request) bool {
+		return r.Header.Get("Idempotency-Key") != "first"
+	}))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/payments", func(w http.ResponseWriter, _ *http.Request) {})
+
+	for _, key := range []string{"", "first", "again"} {
+		req := httptest.NewRequest(http.MethodPost, "/payments", nil)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"retry": "false"}); count != 2 {
+		t.Errorf("expected 2 first attempts, got %d", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"retry": "true"}); count != 1 {
+		t.Errorf("expected 1 retry, got %d", count)
+	}
+}
+
+func TestWithRetryLabelInvalid(t *testing.T) {
+	if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithRetryLabel(" ", nil)); err == nil {
+		t.Error("expected an error for an empty retry header")
+	}
+}
+
+func TestPathVarsLabelDisabledByDefault(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	for _, name := range monitor.requestLabelNames() {
+		if name == "path_vars" {
+			t.Error("expected no path_vars label by default")
+		}
+	}
+}
+
+func TestWithUnknownMethodsFolded(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithUnknownMethodsFolded())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOOBAR", "/", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPatch, "/", nil))
+
+	output := scrape(t, registry, false)
+	for _, expected := range []string{
+		`request_seconds_count{addr="/",errorMessage="",isError="false",method="OTHER",status="200",type="HTTP/1.1"} 1`,
+		`request_seconds_count{addr="/",errorMessage="",isError="false",method="PATCH",status="200",type="HTTP/1.1"} 1`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in output:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "FOOBAR") {
+		t.Errorf("expected the unknown method to be folded:\n%s", output)
+	}
+}
+
+// servePreflight serves a CORS preflight request and a plain OPTIONS request through the monitor
+func servePreflight(monitor *Monitor) {
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users", func(w http.ResponseWriter, _ *http.Request) {})
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/users", nil)
+	preflight.Header.Set("Origin", "https://example.com")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.ServeHTTP(httptest.NewRecorder(), preflight)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodOptions, "/users", nil))
+}
+
+func TestWithPreflightRequestsFolded(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithPreflightRequestsFolded())
+	servePreflight(monitor)
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"method": PreflightMethod}); count != 1 {
+		t.Errorf("expected 1 preflight request, got %d", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"method": http.MethodOptions}); count != 1 {
+		t.Errorf("expected 1 plain OPTIONS request, got %d", count)
+	}
+}
+
+func TestWithoutPreflightRequests(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithoutPreflightRequests())
+	servePreflight(monitor)
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"method": http.MethodOptions}); count != 1 {
+		t.Errorf("expected only the plain OPTIONS request to be recorded, got %d requests", count)
+	}
+}
+
+func TestWithoutMethodLabel(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithoutMethodLabel())
+	serveError(monitor, "")
+
+	labels := requestSecondsLabels(t, monitor)
+	if _, ok := labels["method"]; ok {
+		t.Errorf("expected no method label, got %v", labels)
+	}
+	if labels["addr"] != "/failing" {
+		t.Errorf("expected the remaining labels to be recorded, got %v", labels)
+	}
+}
+
+func TestWithoutMethodLabelOnRouteMetrics(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithoutMethodLabel(), WithSampleRate(0.5), WithSlowRequestThreshold(time.Nanosecond),
+		WithLatencyClasses([]time.Duration{time.Nanosecond, time.Hour}), WithTimeToFirstByte(), WithResponsesWithoutBody())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOOBAR", "/", nil))
+
+	if output := scrape(t, registry, false); strings.Contains(output, "FOOBAR") || strings.Contains(output, "method=") {
+		t.Errorf("expected no method label on any request metric:\n%s", output)
+	}
+	if total := testutil.ToFloat64(monitor.slowRequests.WithLabelValues("/")); total != 1 {
+		t.Errorf("expected the slow request to be counted without method, got %v", total)
+	}
+}
+
+func TestWithLabelNames(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithLabelNames(map[string]string{
+		"status": "code",
+		"addr":   "handler",
+		"method": "verb",
+	}))
+	serveError(monitor, "")
+
+	output := scrape(t, registry, false)
+	if !strings.Contains(output, `request_seconds_count{code="500",errorMessage="",handler="/failing",isError="true",type="HTTP/1.1",verb="GET"} 1`) {
+		t.Errorf("expected the request metrics to use the renamed labels:\n%s", output)
+	}
+}
+
+func TestWithLabelNamesInvalid(t *testing.T) {
+	for name, names := range map[string]map[string]string{
+		"unknown label":     {"route": "handler"},
+		"invalid name":      {"addr": "the handler"},
+		"reserved name":     {"status": "le"},
+		"label collision":   {"status": "addr"},
+		"renamed collision": {"status": "code", "method": "code"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithLabelNames(names)); err == nil {
+				t.Errorf("expected an error renaming labels with %v", names)
+			}
+		})
+	}
+}
+
+func TestWithLabelNamesConstLabelCollision(t *testing.T) {
+	_, err := NewMonitor("v1.0.0",
+		WithRegistry(prometheus.NewRegistry()),
+		WithConstLabels(prometheus.Labels{"code": "x"}),
+		WithLabelNames(map[string]string{"status": "code"}))
+	if err == nil {
+		t.Error("expected an error renaming a label to the name of a constant label")
+	}
+}
+
+func TestWithQueryParamLabels(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithQueryParamLabels("format"))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/reports", func(w http.ResponseWriter, _ *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reports?format=json&token=secret", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reports?format="+strings.Repeat("x", 100), nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reports", nil))
+
+	for format, expected := range map[string]uint64{
+		"json":                                   1,
+		strings.Repeat("x", QueryParamMaxLength): 1,
+		"":                                       1,
+	} {
+		if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"format": format}); count != expected {
+			t.Errorf("expected %d requests with format %q, got %d", expected, format, count)
+		}
+	}
+	if output := scrape(t, registry, false); strings.Contains(output, "token") || strings.Contains(output, "secret") {
+		t.Errorf("expected query parameters outside the allow-list to be dropped:\n%s", output)
+	}
+}
+
+func TestWithQueryParamLabelsBounded(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithQueryParamLabels("format", "page"))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/reports", func(w http.ResponseWriter, _ *http.Request) {})
+
+	for i := 1; i <= ExtraLabelMaxValues+10; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reports?format="+strconv.Itoa(i)+"&page=1", nil))
+	}
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"format": OtherLabelValue, "page": "1"}); count != 10 {
+		t.Errorf("expected the values past the limit to be folded, got %d requests", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"format": "1", "page": "1"}); count != 1 {
+		t.Errorf("expected the values within the limit to be kept, got %d requests", count)
+	}
+}
+
+func TestWithQueryParamLabelsInvalid(t *testing.T) {
+	for _, params := range [][]string{{"page-size"}, {"le"}, {"status"}} {
+		if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithQueryParamLabels(params...)); err == nil {
+			t.Errorf("expected an error for query parameter labels %v", params)
+		}
+	}
+}
+
+// tenantKey is the context key of the tenant ID in extra labels tests
+type tenantKey struct{}
+
+func TestWithExtraLabels(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithExtraLabels([]string{"tenant"}, func(r *http.Request) []string {
+		tenant, _ := r.Context().Value(tenantKey{}).(string)
+		return []string{tenant}
+	}))
+
+	r := mux.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, r.Header.Get("X-Tenant"))))
+		})
+	})
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/orders", func(w http.ResponseWriter, _ *http.Request) {})
+
+	for _, tenant := range []string{"acme", "acme", "globex"} {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("X-Tenant", tenant)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for tenant, expected := range map[string]uint64{"acme": 2, "globex": 1} {
+		if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"tenant": tenant}); count != expected {
+			t.Errorf("expected %d requests of tenant %q, got %d", expected, tenant, count)
+		}
+	}
+}
+
+func TestWithExtraLabelsBounded(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithExtraLabels([]string{"tenant", "region"}, func(r *http.Request) []string {
+		return []string{r.URL.Query().Get("tenant")}
+	}))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/orders", func(w http.ResponseWriter, _ *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders?tenant="+strings.Repeat("x", 100), nil))
+	for i := 1; i <= ExtraLabelMaxValues+10; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders?tenant="+strconv.Itoa(i), nil))
+	}
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"tenant": strings.Repeat("x", ExtraLabelMaxLength), "region": ""}); count != 1 {
+		t.Errorf("expected the long value to be truncated, got %d requests", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"tenant": OtherLabelValue}); count != 11 {
+		t.Errorf("expected the values past the limit to be folded, got %d requests", count)
+	}
+}
+
+func TestWithExtraLabelsInvalid(t *testing.T) {
+	values := func(*http.Request) []string { return nil }
+	for _, option := range []Option{
+		WithExtraLabels([]string{"tenant-id"}, values),
+		WithExtraLabels([]string{"le"}, values),
+		WithExtraLabels([]string{"status"}, values),
+		WithExtraLabels([]string{"tenant"}, nil),
+	} {
+		if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), option); err == nil {
+			t.Error("expected an error for invalid extra labels")
+		}
+	}
+}
+
+func TestErrorMessageLabelSingleLine(t *testing.T) {
+	monitor, registry := newTestMonitor(t)
+	serveError(monitor, "query failed:\n\tconnection reset\r\nretrying\x00")
+
+	labels := requestSecondsLabels(t, monitor)
+	if labels["errorMessage"] != "query failed: connection reset retrying" {
+		t.Errorf("expected the error message to be flattened to a single line, got %q", labels["errorMessage"])
+	}
+
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf("expected well-formed metrics, got %v", err)
+	}
+	for _, line := range strings.Split(scrape(t, registry, false), "\n") {
+		if strings.HasPrefix(line, "connection reset") || strings.HasPrefix(line, "retrying") {
+			t.Errorf("expected no line broken by the error message, got %q", line)
+		}
+	}
+}
+
+func TestSingleLine(t *testing.T) {
+	for s, expected := range map[string]string{
+		"single line":       "single line",
+		"first\nsecond":     "first second",
+		"first\r\n\r\nlast": "first last",
+		"\ntrimmed\n":       "trimmed",
+		"tab\tseparated":    "tab separated",
+		"invalid \xff byte": "invalid � byte",
+	} {
+		if line := singleLine(s); line != expected {
+			t.Errorf("expected %q to become %q, got %q", s, expected, line)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package mux_monitor
+
+import "time"
+
+// Latency classes of the request_latency_class_total counter
+const (
+	LatencyClassFast   = "fast"
+	LatencyClassNormal = "normal"
+	LatencyClassSlow   = "slow"
+)
+
+// latencyClass returns the class of a request duration given the sorted latency class thresholds:
+// fast below the first threshold, slow from the last threshold on and normal in between
+func latencyClass(duration time.Duration, thresholds []time.Duration) string {
+	switch {
+	case duration < thresholds[0]:
+		return LatencyClassFast
+	case duration >= thresholds[len(thresholds)-1]:
+		return LatencyClassSlow
+	default:
+		return LatencyClassNormal
+	}
+}
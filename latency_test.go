@@ -0,0 +1,101 @@
+package mux_monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLatencyClass(t *testing.T) {
+	thresholds := []time.Duration{time.Millisecond * 100, time.Second}
+
+	for duration, expected := range map[time.Duration]string{
+		time.Millisecond * 10:  LatencyClassFast,
+		time.Millisecond * 100: LatencyClassNormal,
+		time.Millisecond * 500: LatencyClassNormal,
+		time.Second:            LatencyClassSlow,
+		time.Minute:            LatencyClassSlow,
+	} {
+		if class := latencyClass(duration, thresholds); class != expected {
+			t.Errorf("expected %s to be %s, got %s", duration, expected, class)
+		}
+	}
+
+	if class := latencyClass(time.Millisecond*500, thresholds[:1]); class != LatencyClassSlow {
+		t.Errorf("expected a single threshold to split fast and slow requests, got %s", class)
+	}
+}
+
+func TestWithLatencyClasses(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	monitor, _ := newTestMonitor(t, WithLatencyClasses([]time.Duration{time.Millisecond * 20, time.Second}),
+		WithClock(func() time.Time { return now }))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/fast", func(w http.ResponseWriter, _ *http.Request) {
+		now = now.Add(time.Millisecond * 19)
+	})
+	r.HandleFunc("/normal", func(w http.ResponseWriter, _ *http.Request) {
+		now = now.Add(time.Millisecond * 30)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/normal", nil))
+
+	if count := testutil.ToFloat64(monitor.latencyClasses.WithLabelValues(http.MethodGet, "/fast", LatencyClassFast)); count != 1 {
+		t.Errorf("expected 1 fast request, got %v", count)
+	}
+	if count := testutil.ToFloat64(monitor.latencyClasses.WithLabelValues(http.MethodGet, "/normal", LatencyClassNormal)); count != 1 {
+		t.Errorf("expected 1 normal request, got %v", count)
+	}
+}
+
+func TestWithLatencyClassesInvalid(t *testing.T) {
+	for _, thresholds := range [][]time.Duration{
+		nil,
+		{time.Millisecond, time.Second, time.Minute},
+		{0},
+		{time.Second, time.Millisecond},
+	} {
+		if _, err := NewMonitor("v1.0.0", WithLatencyClasses(thresholds)); err == nil {
+			t.Errorf("expected an error for thresholds %v", thresholds)
+		}
+	}
+}
+
+func TestWithSlowRequestThreshold(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	monitor, _ := newTestMonitor(t, WithSlowRequestThreshold(time.Millisecond*20), WithClock(func() time.Time { return now }))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/fast", func(w http.ResponseWriter, _ *http.Request) {
+		now = now.Add(time.Millisecond * 20)
+	})
+	r.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		now = now.Add(time.Millisecond * 30)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	if count := testutil.ToFloat64(monitor.slowRequests.WithLabelValues(http.MethodGet, "/slow")); count != 1 {
+		t.Errorf("expected 1 slow request over the threshold, got %v", count)
+	}
+	if count := testutil.ToFloat64(monitor.slowRequests.WithLabelValues(http.MethodGet, "/fast")); count != 0 {
+		t.Errorf("expected no slow request under the threshold, got %v", count)
+	}
+}
+
+func TestWithSlowRequestThresholdInvalid(t *testing.T) {
+	for _, threshold := range []time.Duration{0, -time.Second} {
+		if _, err := NewMonitor("v1.0.0", WithSlowRequestThreshold(threshold)); err == nil {
+			t.Errorf("expected an error for threshold %v", threshold)
+		}
+	}
+}
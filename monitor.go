@@ -1,144 +1,779 @@
 package mux_monitor
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math/rand"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type Monitor struct {
 	reqDuration           *prometheus.HistogramVec
+	reqOverflowDuration   *prometheus.HistogramVec
+	concurrentStreams     *prometheus.HistogramVec
+	timeToFirstByte       *prometheus.HistogramVec
 	dependencyReqDuration *prometheus.HistogramVec
+	grpcReqDuration       *prometheus.HistogramVec
+	dependencyCheckTime   *prometheus.HistogramVec
+	dependencyFailures    *prometheus.CounterVec
 	respSize              *prometheus.CounterVec
+	respSizeHistogram     *prometheus.HistogramVec
+	requestsTotal         *prometheus.CounterVec
+	conditionalHits       *prometheus.CounterVec
+	responsesWithoutBody  *prometheus.CounterVec
+	latencyClasses        *prometheus.CounterVec
+	slowRequests          *prometheus.CounterVec
 	dependencyUP          *prometheus.GaugeVec
+	dependencyLastCheck   *prometheus.GaugeVec
 	applicationInfo       *prometheus.GaugeVec
+	routeAvailability     *routeAvailability
+	topErrors             *topErrors
+	labelCache            *labelCache
+	recorder              Recorder
+	noop                  bool
+	grpcMutex             sync.Mutex
+	checkersMutex         sync.Mutex
+	checkers              []*dependencyCheck
+	statusesMutex         sync.RWMutex
+	dependencyStatuses    map[string]DependencyStatus
+	requestLabels         []requestLabel
+	registered            []prometheus.Collector
+	registerErr           error
 	errorMessageKey       string
 	IsStatusError         func(statusCode int) bool
-}
 
-// DependencyStatus is the type to represent UP or DOWN states
-type DependencyStatus int
+	// settings applied by options
+	buckets                []float64
+	registerer             prometheus.Registerer
+	availabilityWindow     time.Duration
+	dependencyVersions     map[string]string
+	conditionalHitsEnabled bool
+	namespace              string
+	subsystem              string
+	constLabels            prometheus.Labels
+
+	errorMessageMaxLength     int
+	errorMessageSanitizer     func(errorMessage string) string
+	errorMessageLabelDisabled bool
+	pathVarsLabelEnabled      bool
+	schemeLabelEnabled        bool
+	contentTypeLabelEnabled   bool
+	requestType               func(r *http.Request) string
+	requestTypeLimit          *labelValueLimit
+	retryHeader               string
+	isRetry                   func(r *http.Request) bool
+	queryParamLabels          []string
+	queryParamLimits          []*labelValueLimit
+	extraLabelNames           []string
+	extraLabelValues          func(r *http.Request) []string
+	extraLabelLimits          []*labelValueLimit
+	methodLabelDisabled       bool
+	unknownMethodsFolded      bool
+	preflightFolded           bool
+	preflightExcluded         bool
+	trailingSlashTrimmed      bool
+	addrPrefix                string
+
+	overflowThreshold time.Duration
+	overflowBuckets   []float64
+
+	responsesWithoutBodyEnabled bool
+
+	ttfbEnabled bool
+
+	sizeBuckets []float64
+
+	streamBuckets []float64
+
+	latencyThresholds []time.Duration
+
+	slowRequestThreshold time.Duration
+
+	nativeHistogramBucketFactor float64
+
+	millisecondsEnabled bool
+
+	sampleRate float64
+
+	skipRouteNamePrefix string
 
-// DependencyChecker specifies the methods a checker must implement.
-type DependencyChecker interface {
-	GetDependencyName() string
-	Check() DependencyStatus
+	applicationInfoDisabled bool
+
+	expectedStatusCodes      map[int]bool
+	routeExpectedStatusCodes map[string]map[int]bool
+
+	labelNames map[string]string
+
+	dependencyCheckJitter float64
+
+	failureThreshold int
+	successThreshold int
+
+	dependencyStateChangeHook func(name string, previous, current DependencyStatus)
+
+	traceIDFromRequest func(r *http.Request) string
+
+	now func() time.Time
 }
 
+const DefaultErrorMessageKey = "error-message"
+
+// StatusClientClosedRequest is the status recorded for requests whose client disconnected before the handler
+// returned, following the nginx convention
+const StatusClientClosedRequest = 499
+
+// DefaultSkipRouteNamePrefix is the default name prefix of routes whose requests are not instrumented,
+// e.g. r.Handle("/metrics", promhttp.Handler()).Name("nometrics:metrics")
+const DefaultSkipRouteNamePrefix = "nometrics:"
+
+// Limits of the native histograms, resetting a histogram when it reaches the max bucket number
 const (
-	DOWN DependencyStatus = iota
-	UP
+	nativeHistogramMaxBucketNumber  = 160
+	nativeHistogramMinResetDuration = time.Hour
 )
 
-const DefaultErrorMessageKey = "error-message"
+// TraceIDExemplarLabel is the exemplar label holding the trace ID of an observed request
+const TraceIDExemplarLabel = "trace_id"
 
 var (
 	DefaultBuckets = []float64{0.1, 0.3, 1.5, 10.5}
+	// DefaultSizeBuckets are the default buckets of the response_size_bytes_histogram histogram, from 100B to 10MB
+	DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+	// DefaultMillisecondBuckets are the DefaultBuckets in milliseconds, used by default with WithMilliseconds
+	DefaultMillisecondBuckets = []float64{100, 300, 1500, 10500}
+	// DefaultWebBuckets are buckets from 5ms to 10s, denser around common web latencies than DefaultBuckets for
+	// quantile estimates, e.g. WithBuckets(DefaultWebBuckets)
+	DefaultWebBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
 )
 
-//New create new Monitor instance
-func New(applicationVersion string, errorMessageKey string, buckets []float64) (*Monitor, error) {
+// ExponentialBuckets returns count buckets, the first one being start and each following one factor times the
+// previous one. It panics when count isn't positive, start isn't positive or factor isn't greater than 1.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	return prometheus.ExponentialBuckets(start, factor, count)
+}
+
+// LinearBuckets returns count buckets, the first one being start and each following one width greater than the
+// previous one. It panics when count isn't positive.
+func LinearBuckets(start, width float64, count int) []float64 {
+	return prometheus.LinearBuckets(start, width, count)
+}
+
+// New create new Monitor instance. It's equivalent to NewMonitor with the WithErrorMessageKey and WithBuckets options.
+func New(applicationVersion string, errorMessageKey string, buckets []float64, opts ...Option) (*Monitor, error) {
+	return NewMonitor(applicationVersion, append([]Option{WithErrorMessageKey(errorMessageKey), WithBuckets(buckets)}, opts...)...)
+}
+
+// NewNoop creates a Monitor that records nothing, e.g. to disable instrumentation in local development or
+// benchmarks without changing the wiring code. Its middleware passes requests through and its dependency checkers
+// never run, while its metric vectors are registered on a registry of their own.
+func NewNoop() *Monitor {
+	monitor, err := NewMonitor("noop", WithRegistry(prometheus.NewRegistry()))
+	if err != nil {
+		panic(err)
+	}
+	monitor.noop = true
+	return monitor
+}
+
+// NewMonitor creates a new Monitor instance configured by the given options. It returns an error instead of panicking
+// when a metric conflicts with a collector already registered, while monitors created with the same settings on a
+// registry share its collectors.
+func NewMonitor(applicationVersion string, opts ...Option) (*Monitor, error) {
 	if strings.TrimSpace(applicationVersion) == "" {
 		return nil, errors.New("application version must be a non-empty string")
 	}
 
-	if strings.TrimSpace(applicationVersion) == "" {
-		errorMessageKey = DefaultErrorMessageKey
+	monitor := &Monitor{
+		errorMessageKey:       DefaultErrorMessageKey,
+		IsStatusError:         IsStatusError,
+		registerer:            prometheus.DefaultRegisterer,
+		sampleRate:            1,
+		failureThreshold:      1,
+		successThreshold:      1,
+		now:                   time.Now,
+		skipRouteNamePrefix:   DefaultSkipRouteNamePrefix,
+		errorMessageMaxLength: DefaultErrorMessageMaxLength,
 	}
 
-	if buckets == nil {
-		buckets = DefaultBuckets
+	for _, opt := range opts {
+		if err := opt(monitor); err != nil {
+			return nil, err
+		}
 	}
 
-	monitor := &Monitor{errorMessageKey: errorMessageKey, IsStatusError: IsStatusError}
+	if monitor.buckets == nil {
+		monitor.buckets = DefaultBuckets
+		if monitor.millisecondsEnabled {
+			monitor.buckets = DefaultMillisecondBuckets
+		}
+	}
 
-	monitor.reqDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "request_seconds",
-		Help:    "Duration in seconds of HTTP requests.",
-		Buckets: buckets,
-	}, []string{"type", "status", "method", "addr", "isError", "errorMessage"})
+	monitor.requestLabels = monitor.buildRequestLabels()
+	if err := monitor.validateRequestLabels(); err != nil {
+		return nil, err
+	}
+
+	monitor.reqDuration = monitor.newHistogramVec(monitor.nativeHistogramOpts(prometheus.HistogramOpts{
+		Name:    monitor.durationName("request"),
+		Help:    fmt.Sprintf("Duration in %s of HTTP requests.", monitor.durationUnit()),
+		Buckets: monitor.buckets,
+	}), monitor.requestLabelNames())
+
+	if monitor.overflowThreshold > 0 {
+		monitor.reqOverflowDuration = monitor.newHistogramVec(prometheus.HistogramOpts{
+			Name:    monitor.durationName("request_overflow"),
+			Help:    fmt.Sprintf("Duration in %s of HTTP requests slower than the overflow threshold.", monitor.durationUnit()),
+			Buckets: monitor.overflowBuckets,
+		}, monitor.requestLabelNames())
+	}
 
-	monitor.respSize = promauto.NewCounterVec(prometheus.CounterOpts{
+	monitor.respSize = monitor.newCounterVec(prometheus.CounterOpts{
 		Name: "response_size_bytes",
 		Help: "Counts the size of each HTTP response",
-	}, []string{"type", "status", "method", "addr", "isError", "errorMessage"})
+	}, monitor.requestLabelNames())
+
+	if monitor.ttfbEnabled {
+		monitor.timeToFirstByte = monitor.newHistogramVec(prometheus.HistogramOpts{
+			Name:    monitor.durationName("request_ttfb"),
+			Help:    fmt.Sprintf("Time in %s from receiving HTTP requests to writing the first byte of their response body.", monitor.durationUnit()),
+			Buckets: monitor.buckets,
+		}, monitor.routeLabelNames())
+	}
+
+	if monitor.sizeBuckets != nil {
+		monitor.respSizeHistogram = monitor.newHistogramVec(prometheus.HistogramOpts{
+			Name:    "response_size_bytes_histogram",
+			Help:    "Size in bytes of each HTTP response",
+			Buckets: monitor.sizeBuckets,
+		}, monitor.requestLabelNames())
+	}
+
+	if monitor.labelCache != nil {
+		monitor.labelCache.hits = monitor.newCounterVec(prometheus.CounterOpts{
+			Name: "mux_monitor_label_cache_hits_total",
+			Help: "Counts the lookups of request metrics series found in the label cache",
+		}, nil).WithLabelValues()
+		monitor.labelCache.misses = monitor.newCounterVec(prometheus.CounterOpts{
+			Name: "mux_monitor_label_cache_misses_total",
+			Help: "Counts the lookups of request metrics series missing from the label cache",
+		}, nil).WithLabelValues()
+	}
+
+	if monitor.sampleRate < 1 {
+		monitor.requestsTotal = monitor.newCounterVec(prometheus.CounterOpts{
+			Name: "requests_total",
+			Help: "Counts all HTTP requests, including the ones not sampled into the request metrics",
+		}, monitor.routeLabelNames())
+	}
 
-	monitor.dependencyUP = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	if monitor.conditionalHitsEnabled {
+		monitor.conditionalHits = monitor.newCounterVec(prometheus.CounterOpts{
+			Name: "http_conditional_hits_total",
+			Help: "Counts the requests answered with 304 Not Modified",
+		}, []string{monitor.labelName("addr")})
+	}
+
+	if monitor.responsesWithoutBodyEnabled {
+		monitor.responsesWithoutBody = monitor.newCounterVec(prometheus.CounterOpts{
+			Name: "responses_without_body_total",
+			Help: "Counts the requests whose handler returned without calling WriteHeader or Write",
+		}, monitor.routeLabelNames())
+	}
+
+	if monitor.latencyThresholds != nil {
+		monitor.latencyClasses = monitor.newCounterVec(prometheus.CounterOpts{
+			Name: "request_latency_class_total",
+			Help: "Counts the requests by latency class (fast, normal or slow)",
+		}, monitor.routeLabelNames("latency_class"))
+	}
+
+	if monitor.slowRequestThreshold > 0 {
+		monitor.slowRequests = monitor.newCounterVec(prometheus.CounterOpts{
+			Name: "slow_requests_total",
+			Help: "Counts the requests slower than the slow request threshold",
+		}, monitor.routeLabelNames())
+	}
+
+	if monitor.streamBuckets != nil {
+		monitor.concurrentStreams = monitor.newHistogramVec(prometheus.HistogramOpts{
+			Name:    "http2_concurrent_streams",
+			Help:    "Number of streams concurrently served on the connection of each HTTP/2 request.",
+			Buckets: monitor.streamBuckets,
+		}, []string{monitor.labelName("addr")})
+	}
+
+	monitor.dependencyUP = monitor.newGaugeVec(prometheus.GaugeOpts{
 		Name: "dependency_up",
 		Help: "Records if a dependency is up or down. 1 for up, 0 for down",
 	}, []string{"name"})
 
-	monitor.dependencyReqDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	monitor.dependencyLastCheck = monitor.newGaugeVec(prometheus.GaugeOpts{
+		Name: "dependency_last_check_timestamp_seconds",
+		Help: "Unix timestamp in seconds of the last check of a dependency",
+	}, []string{"name"})
+
+	monitor.dependencyReqDuration = monitor.newHistogramVec(monitor.nativeHistogramOpts(prometheus.HistogramOpts{
 		Name:    "dependency_request_seconds",
 		Help:    "Duration of dependency requests in seconds.",
-		Buckets: buckets,
-	}, []string{"name", "type", "status", "method", "addr", "isError", "errorMessage"})
+		Buckets: monitor.secondBuckets(),
+	}), dependencyRequestLabelNames)
 
-	monitor.applicationInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "application_info",
-		Help: "Static information about the application",
-	}, []string{"version"})
-	monitor.applicationInfo.WithLabelValues(applicationVersion).Set(1)
+	monitor.dependencyCheckTime = monitor.newHistogramVec(prometheus.HistogramOpts{
+		Name:    "dependency_check_duration_seconds",
+		Help:    "Duration of dependency checks in seconds.",
+		Buckets: monitor.secondBuckets(),
+	}, []string{"name", "type"})
+
+	monitor.dependencyFailures = monitor.newCounterVec(prometheus.CounterOpts{
+		Name: "dependency_check_failures_total",
+		Help: "Number of dependency checks that returned DOWN.",
+	}, []string{"name"})
+
+	if !monitor.applicationInfoDisabled {
+		monitor.applicationInfo = monitor.newGaugeVec(prometheus.GaugeOpts{
+			Name: "application_info",
+			Help: "Static information about the application",
+		}, []string{"version"})
+		monitor.applicationInfo.WithLabelValues(applicationVersion).Set(1)
+	}
+
+	applicationStartTime := monitor.newGaugeVec(prometheus.GaugeOpts{
+		Name: "application_start_time_seconds",
+		Help: "Unix timestamp in seconds of when the application started",
+	}, nil)
+	applicationStartTime.WithLabelValues().Set(timestampSeconds(monitor.now()))
+
+	if len(monitor.dependencyVersions) > 0 {
+		dependencyVersionsInfo := monitor.newGaugeVec(prometheus.GaugeOpts{
+			Name: "dependency_versions_info",
+			Help: "Static information about the versions of the application dependencies",
+		}, []string{"component", "version"})
+		for component, version := range monitor.dependencyVersions {
+			dependencyVersionsInfo.WithLabelValues(component, version).Set(1)
+		}
+	}
+
+	if monitor.availabilityWindow > 0 {
+		monitor.routeAvailability = newRouteAvailability(monitor.newGaugeVec(prometheus.GaugeOpts{
+			Name: "http_route_availability",
+			Help: "Ratio of successful requests per route over the availability window. 1 for fully available",
+		}, []string{monitor.labelName("addr")}))
+	}
+
+	if monitor.registerErr != nil {
+		monitor.unregister()
+		return nil, fmt.Errorf("registering metrics: %w", monitor.registerErr)
+	}
+
+	if monitor.routeAvailability != nil {
+		monitor.routeAvailability.run(monitor.availabilityWindow)
+	}
 
 	return monitor, nil
 }
 
-func (m *Monitor) collectTime(reqType, status, method, addr, isError, errorMessage string, durationSeconds float64) {
-	m.reqDuration.WithLabelValues(reqType, status, method, addr, isError, errorMessage).Observe(durationSeconds)
+// nativeHistogramOpts configures the histogram as a native histogram without static buckets, when enabled
+func (m *Monitor) nativeHistogramOpts(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	if m.nativeHistogramBucketFactor > 0 {
+		opts.Buckets = nil
+		opts.NativeHistogramBucketFactor = m.nativeHistogramBucketFactor
+		opts.NativeHistogramMaxBucketNumber = nativeHistogramMaxBucketNumber
+		opts.NativeHistogramMinResetDuration = nativeHistogramMinResetDuration
+	}
+	return opts
+}
+
+// newHistogramVec creates and registers a HistogramVec using the namespace, subsystem and constant labels of the monitor
+func (m *Monitor) newHistogramVec(opts prometheus.HistogramOpts, labelNames []string) *prometheus.HistogramVec {
+	opts.Namespace, opts.Subsystem, opts.ConstLabels = m.namespace, m.subsystem, m.constLabels
+	histogram := prometheus.NewHistogramVec(opts, labelNames)
+	if existing, ok := m.register(histogram).(*prometheus.HistogramVec); ok {
+		return existing
+	}
+	return histogram
+}
+
+// newCounterVec creates and registers a CounterVec using the namespace, subsystem and constant labels of the monitor
+func (m *Monitor) newCounterVec(opts prometheus.CounterOpts, labelNames []string) *prometheus.CounterVec {
+	opts.Namespace, opts.Subsystem, opts.ConstLabels = m.namespace, m.subsystem, m.constLabels
+	counter := prometheus.NewCounterVec(opts, labelNames)
+	if existing, ok := m.register(counter).(*prometheus.CounterVec); ok {
+		return existing
+	}
+	return counter
+}
+
+// newGaugeVec creates and registers a GaugeVec using the namespace, subsystem and constant labels of the monitor
+func (m *Monitor) newGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *prometheus.GaugeVec {
+	opts.Namespace, opts.Subsystem, opts.ConstLabels = m.namespace, m.subsystem, m.constLabels
+	gauge := prometheus.NewGaugeVec(opts, labelNames)
+	if existing, ok := m.register(gauge).(*prometheus.GaugeVec); ok {
+		return existing
+	}
+	return gauge
+}
+
+// register registers the collector on the registerer of the monitor, returning it, or the collector already
+// registered with the same descriptors so monitors sharing a registry record the same series. Other registration
+// errors are kept in registerErr and returned by the constructor, and later collectors are not registered.
+func (m *Monitor) register(collector prometheus.Collector) prometheus.Collector {
+	if m.registerErr != nil {
+		return collector
+	}
+
+	err := m.registerer.Register(collector)
+	if err == nil {
+		m.registered = append(m.registered, collector)
+		return collector
+	}
+
+	var alreadyRegistered prometheus.AlreadyRegisteredError
+	if errors.As(err, &alreadyRegistered) && reflect.TypeOf(alreadyRegistered.ExistingCollector) == reflect.TypeOf(collector) {
+		return alreadyRegistered.ExistingCollector
+	}
+	m.registerErr = err
+	return collector
+}
+
+// unregister unregisters the collectors registered by the monitor, when its constructor fails
+func (m *Monitor) unregister() {
+	for _, collector := range m.registered {
+		m.registerer.Unregister(collector)
+	}
+	m.registered = nil
 }
 
-func (m *Monitor) collectSize(reqType, status, method, addr, isError, errorMessage string, size float64) {
-	m.respSize.WithLabelValues(reqType, status, method, addr, isError, errorMessage).Add(size)
+// durationUnit returns the unit of the request histograms
+func (m *Monitor) durationUnit() string {
+	if m.millisecondsEnabled {
+		return "milliseconds"
+	}
+	return "seconds"
 }
 
-// CollectDependencyTime collet the duration of dependency requests in seconds
-func (m *Monitor) CollectDependencyTime(name, reqType, status, method, addr, isError, errorMessage string, durationSeconds float64) {
-	m.dependencyReqDuration.WithLabelValues(name, reqType, status, method, addr, isError, errorMessage).Observe(durationSeconds)
+// durationName returns the name of a request histogram, suffixed by its unit
+func (m *Monitor) durationName(name string) string {
+	return name + "_" + m.durationUnit()
+}
+
+// durationValue returns a duration in the unit of the request histograms
+func (m *Monitor) durationValue(duration time.Duration) float64 {
+	if m.millisecondsEnabled {
+		return float64(duration) / float64(time.Millisecond)
+	}
+	return duration.Seconds()
+}
+
+// secondBuckets returns the buckets of the histograms always recorded in seconds, converting the request histogram
+// buckets when they're in milliseconds
+func (m *Monitor) secondBuckets() []float64 {
+	if !m.millisecondsEnabled {
+		return m.buckets
+	}
+	buckets := make([]float64, len(m.buckets))
+	for i, bucket := range m.buckets {
+		buckets[i] = bucket / 1000
+	}
+	return buckets
+}
+
+func (m *Monitor) collectTime(labelValues []string, traceID string, duration time.Duration) {
+	durationValue := m.durationValue(duration)
+
+	histogram := m.reqDuration
+	if m.reqOverflowDuration != nil && duration > m.overflowThreshold {
+		histogram = m.reqOverflowDuration
+	}
+
+	var observer prometheus.Observer
+	switch {
+	case m.labelCache == nil:
+		observer = histogram.WithLabelValues(labelValues...)
+	case histogram == m.reqOverflowDuration:
+		observer = m.labelCache.observer(&m.labelCache.overflowDurations, histogram, labelCacheKey(labelValues), labelValues)
+	default:
+		observer = m.labelCache.observer(&m.labelCache.durations, histogram, labelCacheKey(labelValues), labelValues)
+	}
+	if isValidTraceID(traceID) {
+		if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+			exemplarObserver.ObserveWithExemplar(durationValue, prometheus.Labels{TraceIDExemplarLabel: traceID})
+			return
+		}
+	}
+	observer.Observe(durationValue)
+}
+
+func (m *Monitor) collectSize(labelValues []string, size float64) {
+	if m.respSizeHistogram != nil {
+		m.respSizeHistogram.WithLabelValues(labelValues...).Observe(size)
+	}
+
+	if m.labelCache == nil {
+		m.respSize.WithLabelValues(labelValues...).Add(size)
+		return
+	}
+	m.labelCache.counter(&m.labelCache.sizes, m.respSize, labelCacheKey(labelValues), labelValues).Add(size)
+}
+
+// RequestDuration returns the request_seconds histogram vector, e.g. to pre-create label combinations
+func (m *Monitor) RequestDuration() *prometheus.HistogramVec {
+	return m.reqDuration
+}
+
+// ResponseSize returns the response_size_bytes counter vector
+func (m *Monitor) ResponseSize() *prometheus.CounterVec {
+	return m.respSize
+}
+
+// DependencyUp returns the dependency_up gauge vector
+func (m *Monitor) DependencyUp() *prometheus.GaugeVec {
+	return m.dependencyUP
+}
+
+// DependencyRequestDuration returns the dependency_request_seconds histogram vector
+func (m *Monitor) DependencyRequestDuration() *prometheus.HistogramVec {
+	return m.dependencyReqDuration
 }
 
 // Prometheus implements mux.MiddlewareFunc.
 func (m *Monitor) Prometheus(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		respWriter := NewResponseWriter(w)
+		if m.noop || m.skipped(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		respWriter := newResponseWriter(w, m.now)
 
-		route := mux.CurrentRoute(r)
-		path, _ := route.GetPathTemplate()
+		path := m.addrLabel(m.requestPath(r))
+
+		endStream := m.startStream(r, path)
+		defer endStream()
 
 		next.ServeHTTP(respWriter, r)
 
-		duration := time.Since(respWriter.started)
+		m.record(r, respWriter, path)
+	})
+}
+
+// WrapHandler instruments a single handler, labeling its requests with the given addr instead of the path of a
+// matched route, e.g. http.Handle("/health", monitor.WrapHandler("/health", healthHandler)) without a router
+func (m *Monitor) WrapHandler(addr string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.noop || m.skipped(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		respWriter := newResponseWriter(w, m.now)
 
-		statusCodeStr := respWriter.StatusCodeStr()
-		isErrorStr := strconv.FormatBool(m.IsStatusError(respWriter.statusCode))
+		endStream := m.startStream(r, addr)
+		defer endStream()
 
-		errorMessage := r.Header.Get(m.errorMessageKey)
-		r.Header.Del(m.errorMessageKey)
+		h.ServeHTTP(respWriter, r)
 
-		m.collectTime(r.Proto, statusCodeStr, r.Method, path, isErrorStr, errorMessage, duration.Seconds())
-		m.collectSize(r.Proto, statusCodeStr, r.Method, path, isErrorStr, errorMessage, float64(respWriter.Count()))
+		m.record(r, respWriter, addr)
 	})
 }
 
-// AddDependencyChecker creates a ticker that periodically executes the checker and collects the dependency state metrics
-func (m *Monitor) AddDependencyChecker(checker DependencyChecker, checkingPeriod time.Duration) {
-	ticker := time.NewTicker(checkingPeriod)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				status := checker.Check()
-				m.dependencyUP.WithLabelValues(checker.GetDependencyName()).Set(float64(status))
-			}
+// record collects the metrics of a request served through respWriter, labeled with the path
+func (m *Monitor) record(r *http.Request, respWriter *ResponseWriter, path string) {
+	duration := m.now().Sub(respWriter.started)
+	statusCode := respWriter.StatusCode()
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		statusCode = StatusClientClosedRequest
+	}
+
+	o := &observation{
+		request:      r,
+		method:       m.methodLabel(r),
+		statusCode:   statusCode,
+		addr:         path,
+		isError:      m.isError(r, statusCode),
+		errorMessage: m.boundErrorMessage(r.Header.Get(m.errorMessageKey)),
+		extraLabels:  m.extraLabels(r),
+	}
+	if m.contentTypeLabelEnabled {
+		o.contentType = contentTypeLabel(respWriter.Header().Get("Content-Type"))
+	}
+	r.Header.Del(m.errorMessageKey)
+
+	if m.requestsTotal != nil {
+		m.requestsTotal.WithLabelValues(m.routeLabelValues(o)...).Inc()
+	}
+
+	if m.sampled() {
+		m.collectRequest(r, m.requestLabelValues(o), duration, respWriter.Count())
+	}
+
+	if m.timeToFirstByte != nil {
+		if ttfb, ok := respWriter.TimeToFirstByte(); ok {
+			m.timeToFirstByte.WithLabelValues(m.routeLabelValues(o)...).Observe(m.durationValue(ttfb))
 		}
-	}()
+	}
+
+	if m.conditionalHits != nil && statusCode == http.StatusNotModified {
+		m.conditionalHits.WithLabelValues(path).Inc()
+	}
+
+	if m.topErrors != nil && o.errorMessage != "" {
+		m.topErrors.add(o.errorMessage)
+	}
+
+	if m.latencyClasses != nil {
+		m.latencyClasses.WithLabelValues(m.routeLabelValues(o, latencyClass(duration, m.latencyThresholds))...).Inc()
+	}
+
+	if m.slowRequests != nil && duration > m.slowRequestThreshold {
+		m.slowRequests.WithLabelValues(m.routeLabelValues(o)...).Inc()
+	}
+
+	if m.responsesWithoutBody != nil && !respWriter.Written() {
+		m.responsesWithoutBody.WithLabelValues(m.routeLabelValues(o)...).Inc()
+	}
+
+	if m.routeAvailability != nil {
+		m.routeAvailability.record(path, o.isError)
+	}
+}
+
+// RegisterGRPCMetrics creates and registers the grpc_request_seconds histogram, so services without gRPC don't
+// export it. It's called by the grpcmonitor package when building its interceptors, before serving requests, and
+// does nothing when the histogram is already registered.
+func (m *Monitor) RegisterGRPCMetrics() error {
+	m.grpcMutex.Lock()
+	defer m.grpcMutex.Unlock()
+
+	if m.noop || m.grpcReqDuration != nil {
+		return nil
+	}
+
+	histogram := m.newHistogramVec(m.nativeHistogramOpts(prometheus.HistogramOpts{
+		Name:    m.durationName("grpc_request"),
+		Help:    fmt.Sprintf("Duration in %s of gRPC requests.", m.durationUnit()),
+		Buckets: m.buckets,
+	}), []string{"service", "method", "status", "isError"})
+	if err := m.registerErr; err != nil {
+		m.registerErr = nil
+		return fmt.Errorf("registering metrics: %w", err)
+	}
+	m.grpcReqDuration = histogram
+	return nil
+}
+
+// CollectGRPCTime collects the duration of a gRPC request served by the application on the grpc_request_seconds
+// histogram, labeled by its service, method and status code name, e.g. NotFound. It's called by the interceptors of
+// the grpcmonitor package, and does nothing until RegisterGRPCMetrics is called.
+func (m *Monitor) CollectGRPCTime(service, method, status string, isError bool, duration time.Duration) {
+	if m.noop || m.grpcReqDuration == nil {
+		return
+	}
+	m.grpcReqDuration.WithLabelValues(service, method, status, strconv.FormatBool(isError)).Observe(m.durationValue(duration))
+}
+
+// Now returns the current time of the monitor clock, time.Now unless set by WithClock, so that durations measured
+// outside the middleware, e.g. by the grpcmonitor interceptors, follow the same clock
+func (m *Monitor) Now() time.Time {
+	return m.now()
+}
+
+// skipped reports whether the request is an excluded CORS preflight request, or matched a route marked to skip
+// instrumentation by its name prefix
+func (m *Monitor) skipped(r *http.Request) bool {
+	if m.preflightExcluded && isPreflight(r) {
+		return true
+	}
+	if m.skipRouteNamePrefix == "" {
+		return false
+	}
+	route := mux.CurrentRoute(r)
+	return route != nil && strings.HasPrefix(route.GetName(), m.skipRouteNamePrefix)
+}
+
+// timestampSeconds returns the Unix timestamp in seconds of t
+func timestampSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// sampled reports whether the request_seconds and response_size_bytes metrics of a request must be recorded
+func (m *Monitor) sampled() bool {
+	return m.sampleRate >= 1 || rand.Float64() < m.sampleRate
+}
+
+// requestPath returns the addr label value of a request: the path template of the matched route, or UnmatchedAddr
+// when no route was matched. Templates of subrouters include their parent prefixes, and the prefix set by
+// WithAddrPrefix is prepended, so that the same subpath under different mount points gets distinct values.
+func (m *Monitor) requestPath(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return UnmatchedAddr
+	}
+	path, _ := route.GetPathTemplate()
+	return joinPath(m.addrPrefix, path)
+}
+
+// isError classifies the status code of a request, excluding the status codes expected globally or by its route
+func (m *Monitor) isError(r *http.Request, statusCode int) bool {
+	if m.expectedStatusCodes[statusCode] {
+		return false
+	}
+	if route := mux.CurrentRoute(r); route != nil && m.routeExpectedStatusCodes[route.GetName()][statusCode] {
+		return false
+	}
+	return m.IsStatusError(statusCode)
+}
+
+// addrLabel returns the addr label value of a request path, normalized as configured
+func (m *Monitor) addrLabel(path string) string {
+	if m.trailingSlashTrimmed {
+		return trimTrailingSlash(path)
+	}
+	return path
+}
+
+// trimTrailingSlash removes the trailing slash of an addr label value, keeping the root path
+func trimTrailingSlash(path string) string {
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" || path == "" {
+		return trimmed
+	}
+	return "/"
+}
+
+// joinPath joins a prefix and a path without doubling the slash between them
+func joinPath(prefix, path string) string {
+	if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, "/") {
+		return prefix + path[1:]
+	}
+	return prefix + path
+}
+
+// TraceIDFromHeader returns a WithTraceIDFromRequest function reading the trace ID from the given request header
+func TraceIDFromHeader(header string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// isValidTraceID reports whether traceID can be attached as an exemplar without exceeding the exemplar size limit
+func isValidTraceID(traceID string) bool {
+	if traceID == "" || !utf8.ValidString(traceID) {
+		return false
+	}
+	return utf8.RuneCountInString(TraceIDExemplarLabel)+utf8.RuneCountInString(traceID) <= prometheus.ExemplarMaxRunes
 }
 
+// IsStatusError is the default predicate of a Monitor, reporting informational (1xx), client error (4xx)
+// and server error (5xx) status codes as errors. Redirects (3xx) are not errors.
 func IsStatusError(statusCode int) bool {
 	return statusCode < 200 || statusCode >= 400
 }
@@ -0,0 +1,1047 @@
+package mux_monitor
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/mux-monitor/muxmonitortest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestMonitor creates a Monitor whose metrics are registered on a fresh registry
+func newTestMonitor(t *testing.T, opts ...Option) (*Monitor, *prometheus.Registry) {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	monitor, err := NewMonitor("v1.0.0", append([]Option{WithRegistry(registry)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return monitor, registry
+}
+
+// scrape returns the metrics exposition of the registry, using OpenMetrics when openMetrics is true
+func scrape(t *testing.T, registry *prometheus.Registry, openMetrics bool) string {
+	t.Helper()
+
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: openMetrics})
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if openMetrics {
+		req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestPrometheusExemplar(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithTraceIDFromRequest(TraceIDFromHeader("X-Trace-Id")))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/traced", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/traced", nil)
+	req.Header.Set("X-Trace-Id", "4bf92f3577b34da6a3ce929d0e0e4736")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	output := scrape(t, registry, true)
+	if !strings.Contains(output, `# {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"}`) {
+		t.Errorf("expected exemplar with trace ID in output:\n%s", output)
+	}
+}
+
+func TestWithTraceIDFromRequestNil(t *testing.T) {
+	if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithTraceIDFromRequest(nil)); err == nil {
+		t.Error("expected an error for a nil trace ID function")
+	}
+}
+
+func TestPrometheusWithoutTraceID(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithTraceIDFromRequest(TraceIDFromHeader("X-Trace-Id")))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/untraced", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/untraced", nil))
+
+	output := scrape(t, registry, true)
+	if !strings.Contains(output, `request_seconds_count{`) {
+		t.Fatalf("expected request_seconds to be observed:\n%s", output)
+	}
+	if strings.Contains(output, TraceIDExemplarLabel) {
+		t.Errorf("expected no exemplar without a trace ID:\n%s", output)
+	}
+}
+
+func TestIsStatusError(t *testing.T) {
+	for statusCode, expected := range map[int]bool{
+		http.StatusContinue:            true,
+		http.StatusOK:                  false,
+		http.StatusNoContent:           false,
+		http.StatusMovedPermanently:    false,
+		http.StatusNotModified:         false,
+		http.StatusNotFound:            true,
+		http.StatusInternalServerError: true,
+	} {
+		if isError := IsStatusError(statusCode); isError != expected {
+			t.Errorf("expected IsStatusError(%d) to be %v, got %v", statusCode, expected, isError)
+		}
+	}
+}
+
+func TestWithIsStatusError(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithIsStatusError(func(statusCode int) bool {
+		return statusCode >= 400 && statusCode != http.StatusNotFound
+	}))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/missing", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.HandleFunc("/failing", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/failing", nil))
+
+	output := scrape(t, registry, false)
+	if !strings.Contains(output, `request_seconds_count{addr="/missing",errorMessage="",isError="false",method="GET",status="404",type="HTTP/1.1"} 1`) {
+		t.Errorf("expected 404 to be reported as non-error:\n%s", output)
+	}
+	if !strings.Contains(output, `request_seconds_count{addr="/failing",errorMessage="",isError="true",method="GET",status="500",type="HTTP/1.1"} 1`) {
+		t.Errorf("expected 500 to be reported as error:\n%s", output)
+	}
+}
+
+func TestWithIsStatusErrorNil(t *testing.T) {
+	if _, err := New("v1.0.0", DefaultErrorMessageKey, DefaultBuckets, WithIsStatusError(nil)); err == nil {
+		t.Error("expected an error for a nil predicate")
+	}
+}
+
+func TestWithRouteExpectedStatusCodes(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithRouteExpectedStatusCodes("cache", http.StatusNotFound))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	notFound := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	r.HandleFunc("/cache/{key}", notFound).Name("cache")
+	r.HandleFunc("/users/{id}", notFound)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache/a", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	output := scrape(t, registry, false)
+	if !strings.Contains(output, `request_seconds_count{addr="/cache/{key}",errorMessage="",isError="false",method="GET",status="404",type="HTTP/1.1"} 1`) {
+		t.Errorf("expected 404 on the cache route to be reported as non-error:\n%s", output)
+	}
+	if !strings.Contains(output, `request_seconds_count{addr="/users/{id}",errorMessage="",isError="true",method="GET",status="404",type="HTTP/1.1"} 1`) {
+		t.Errorf("expected 404 elsewhere to be reported as error:\n%s", output)
+	}
+}
+
+func TestWithExpectedStatusCodes(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithExpectedStatusCodes(http.StatusUnauthorized))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/private", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/private", nil))
+
+	output := scrape(t, registry, false)
+	if !strings.Contains(output, `request_seconds_count{addr="/private",errorMessage="",isError="false",method="GET",status="401",type="HTTP/1.1"} 1`) {
+		t.Errorf("expected 401 to be reported as non-error:\n%s", output)
+	}
+}
+
+func TestWithDependencyVersions(t *testing.T) {
+	_, registry := newTestMonitor(t, WithDependencyVersions(map[string]string{
+		"github.com/gorilla/mux":              "v1.7.4",
+		"github.com/prometheus/client_golang": "v1.11.1",
+	}))
+
+	output := scrape(t, registry, false)
+	for _, expected := range []string{
+		`dependency_versions_info{component="github.com/gorilla/mux",version="v1.7.4"} 1`,
+		`dependency_versions_info{component="github.com/prometheus/client_golang",version="v1.11.1"} 1`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestWithConditionalHits(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithConditionalHits())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/resource", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("resource"))
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/resource", nil))
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/resource", nil)
+		req.Header.Set("If-None-Match", `"v1"`)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if hits := testutil.ToFloat64(monitor.conditionalHits.WithLabelValues("/resource")); hits != 2 {
+		t.Errorf("expected 2 conditional hits, got %v", hits)
+	}
+}
+
+func TestConditionalHitsDisabledByDefault(t *testing.T) {
+	monitor, registry := newTestMonitor(t)
+
+	if monitor.conditionalHits != nil {
+		t.Error("expected conditional hits to be disabled by default")
+	}
+	if output := scrape(t, registry, false); strings.Contains(output, "http_conditional_hits_total") {
+		t.Errorf("expected no conditional hits metric:\n%s", output)
+	}
+}
+
+func TestWithNamespaceAndSubsystem(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithNamespace("myteam"), WithSubsystem("myservice"))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+
+	for _, expected := range []string{"myteam_myservice_request_seconds", "myteam_myservice_response_size_bytes", "myteam_myservice_application_info"} {
+		if !names[expected] {
+			t.Errorf("expected metric %s to be registered, got %v", expected, names)
+		}
+	}
+	for name := range names {
+		if !strings.HasPrefix(name, "myteam_myservice_") {
+			t.Errorf("expected metric %s to be prefixed with namespace and subsystem", name)
+		}
+	}
+}
+
+func TestWithoutNamespace(t *testing.T) {
+	monitor, registry := newTestMonitor(t)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if output := scrape(t, registry, false); !strings.Contains(output, "\nrequest_seconds_count{") {
+		t.Errorf("expected bare metric names without a namespace:\n%s", output)
+	}
+}
+
+func TestWithNamespaceInvalid(t *testing.T) {
+	if _, err := New("v1.0.0", DefaultErrorMessageKey, DefaultBuckets, WithNamespace("my-team")); err == nil {
+		t.Error("expected an error for an invalid namespace")
+	}
+}
+
+func TestWithConstLabels(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithConstLabels(prometheus.Labels{"region": "sa-east-1", "env": "production"}))
+	monitor.AddDependencyChecker(&constantChecker{name: "database", status: UP}, time.Millisecond)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	time.Sleep(time.Millisecond * 20)
+
+	output := scrape(t, registry, false)
+	for _, expected := range []string{
+		`request_seconds_count{addr="/",env="production",errorMessage="",isError="false",method="GET",region="sa-east-1",status="200",type="HTTP/1.1"} 1`,
+		`response_size_bytes{addr="/",env="production",errorMessage="",isError="false",method="GET",region="sa-east-1",status="200",type="HTTP/1.1"} 0`,
+		`dependency_up{env="production",name="database",region="sa-east-1"} 1`,
+		`application_info{env="production",region="sa-east-1",version="v1.0.0"} 1`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestWithConstLabelsInvalid(t *testing.T) {
+	if _, err := New("v1.0.0", DefaultErrorMessageKey, DefaultBuckets, WithConstLabels(prometheus.Labels{"my-region": "x"})); err == nil {
+		t.Error("expected an error for an invalid label name")
+	}
+}
+
+// sampleCount returns the number of observations of the histogram series with the given label values
+func sampleCount(t *testing.T, histogram *prometheus.HistogramVec, labelValues ...string) uint64 {
+	t.Helper()
+
+	var metric dto.Metric
+	if err := histogram.WithLabelValues(labelValues...).(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestWithOverflowHistogram(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithOverflowHistogram(time.Second*10, []float64{30, 60, 120}))
+	labelValues := []string{"HTTP/1.1", "200", "GET", "/", "false", ""}
+
+	monitor.collectTime(labelValues, "", time.Millisecond*200)
+	monitor.collectTime(labelValues, "", time.Second*10)
+	monitor.collectTime(labelValues, "", time.Second*45)
+	monitor.collectTime(labelValues, "", time.Minute*5)
+
+	if count := sampleCount(t, monitor.reqDuration, labelValues...); count != 2 {
+		t.Errorf("expected 2 requests up to the threshold in request_seconds, got %d", count)
+	}
+	if count := sampleCount(t, monitor.reqOverflowDuration, labelValues...); count != 2 {
+		t.Errorf("expected 2 requests over the threshold in request_overflow_seconds, got %d", count)
+	}
+}
+
+func TestWithOverflowHistogramInvalid(t *testing.T) {
+	if _, err := New("v1.0.0", DefaultErrorMessageKey, DefaultBuckets, WithOverflowHistogram(0, []float64{30})); err == nil {
+		t.Error("expected an error for a non-positive threshold")
+	}
+	if _, err := New("v1.0.0", DefaultErrorMessageKey, DefaultBuckets, WithOverflowHistogram(time.Second, nil)); err == nil {
+		t.Error("expected an error for empty buckets")
+	}
+}
+
+func TestNewMonitorDefaults(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	defaultRegisterer := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = registry
+	defer func() { prometheus.DefaultRegisterer = defaultRegisterer }()
+
+	monitor, err := NewMonitor("v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if monitor.errorMessageKey != DefaultErrorMessageKey {
+		t.Errorf("expected the default error message key, got %s", monitor.errorMessageKey)
+	}
+	if len(monitor.buckets) != len(DefaultBuckets) {
+		t.Errorf("expected the default buckets, got %v", monitor.buckets)
+	}
+	if output := scrape(t, registry, false); !strings.Contains(output, `application_info{version="v1.0.0"} 1`) {
+		t.Errorf("expected the metrics to be registered on the default registerer:\n%s", output)
+	}
+}
+
+func TestApplicationStartTime(t *testing.T) {
+	before := time.Now()
+	_, registry := newTestMonitor(t)
+	after := time.Now()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "application_start_time_seconds" {
+			continue
+		}
+		startTime := family.GetMetric()[0].GetGauge().GetValue()
+		if startTime < float64(before.Unix()) || startTime > float64(after.UnixNano())/1e9 {
+			t.Errorf("expected a start time between %v and %v, got %f", before, after, startTime)
+		}
+		return
+	}
+	t.Error("expected the application_start_time_seconds gauge to be registered")
+}
+
+func TestWithoutApplicationInfo(t *testing.T) {
+	_, registry := newTestMonitor(t, WithoutApplicationInfo())
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "application_info" {
+			t.Error("expected the application_info gauge not to be registered")
+		}
+	}
+
+	if _, err := NewMonitor(" ", WithRegistry(prometheus.NewRegistry()), WithoutApplicationInfo()); err == nil {
+		t.Error("expected the version to be validated without application_info")
+	}
+}
+
+func TestNewMonitorRegistrationConflict(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dependency_up",
+		Help: "Conflicting metric",
+	}, []string{"host"}))
+
+	monitor, err := NewMonitor("v1.0.0", WithRegistry(registry))
+	if err == nil || monitor != nil {
+		t.Fatal("expected an error for a conflicting metric")
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "dependency_up" {
+			t.Errorf("expected the metrics registered before the conflict to be unregistered, got %s", family.GetName())
+		}
+	}
+}
+
+func TestNewMonitorSharedRegistry(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	first, err := NewMonitor("v1.0.0", WithRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewMonitor("v1.0.0", WithRegistry(registry))
+	if err != nil {
+		t.Fatalf("expected the second monitor to reuse the registered metrics, got %v", err)
+	}
+
+	second.SetDependencyStatus("database", UP)
+	if up, ok := muxmonitortest.GaugeValue(t, first.dependencyUP, prometheus.Labels{"name": "database"}); !ok || up != 1 {
+		t.Error("expected both monitors to record the same series")
+	}
+}
+
+func TestGRPCMetricsRegisteredOnDemand(t *testing.T) {
+	monitor, registry := newTestMonitor(t)
+
+	monitor.CollectGRPCTime("users.UserService", "GetUser", "OK", false, time.Millisecond)
+	if output := scrape(t, registry, false); strings.Contains(output, "grpc_request") {
+		t.Errorf("expected no gRPC metrics before registering them:\n%s", output)
+	}
+
+	if err := monitor.RegisterGRPCMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	monitor.CollectGRPCTime("users.UserService", "GetUser", "OK", false, time.Millisecond)
+	if output := scrape(t, registry, false); !strings.Contains(output, `grpc_request_seconds_count{isError="false",method="GetUser",service="users.UserService",status="OK"} 1`) {
+		t.Errorf("expected the gRPC request to be recorded:\n%s", output)
+	}
+}
+
+func TestNewMonitorOptions(t *testing.T) {
+	monitor, registry := newTestMonitor(t,
+		WithErrorMessageKey("X-Error"),
+		WithBuckets([]float64{1, 2}),
+		WithNamespace("myteam"),
+	)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/failing", func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Error", "boom")
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/failing", nil))
+
+	output := scrape(t, registry, false)
+	for _, expected := range []string{
+		`myteam_request_seconds_bucket{addr="/failing",errorMessage="boom",isError="true",method="GET",status="500",type="HTTP/1.1",le="1"} 1`,
+		`myteam_request_seconds_bucket{addr="/failing",errorMessage="boom",isError="true",method="GET",status="500",type="HTTP/1.1",le="2"} 1`,
+		`myteam_application_info{version="v1.0.0"} 1`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in output:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, `le="0.1"`) {
+		t.Errorf("expected the default buckets to be replaced:\n%s", output)
+	}
+}
+
+func TestNewMonitorBlankOptions(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithErrorMessageKey(" "), WithBuckets(nil))
+
+	if monitor.errorMessageKey != DefaultErrorMessageKey {
+		t.Errorf("expected a blank error message key to fall back to the default, got %q", monitor.errorMessageKey)
+	}
+	if len(monitor.buckets) != len(DefaultBuckets) {
+		t.Errorf("expected nil buckets to fall back to the default, got %v", monitor.buckets)
+	}
+}
+
+func TestNewMonitorInvalid(t *testing.T) {
+	if _, err := NewMonitor(" "); err == nil {
+		t.Error("expected an error for a blank application version")
+	}
+	if _, err := NewMonitor("v1.0.0", WithRegistry(nil)); err == nil {
+		t.Error("expected an error for a nil registerer")
+	}
+}
+
+func TestNewWrapsNewMonitor(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	monitor, err := New("v1.0.0", "X-Error", []float64{1, 2}, WithRegistry(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if monitor.errorMessageKey != "X-Error" || len(monitor.buckets) != 2 {
+		t.Errorf("expected the positional arguments to be applied, got %q and %v", monitor.errorMessageKey, monitor.buckets)
+	}
+}
+
+func TestNewInvalidBuckets(t *testing.T) {
+	for name, test := range map[string]struct {
+		buckets []float64
+		err     string
+	}{
+		"unsorted":  {[]float64{0.1, 1.5, 0.3}, "request buckets must be strictly increasing, got 0.3 after 1.5 at index 2"},
+		"duplicate": {[]float64{0.1, 0.3, 0.3}, "request buckets must be strictly increasing, got 0.3 after 0.3 at index 2"},
+		"negative":  {[]float64{-1, 0.3, 1.5}, "request buckets must be positive, got -1 at index 0"},
+		"zero":      {[]float64{0, 0.3}, "request buckets must be positive, got 0 at index 0"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New("v1.0.0", DefaultErrorMessageKey, test.buckets, WithRegistry(prometheus.NewRegistry()))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestInvalidOptionBuckets(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"overflow": WithOverflowHistogram(time.Second, []float64{60, 30}),
+		"stream":   WithHTTP2ConcurrentStreams([]float64{1, 1}),
+	} {
+		if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), opt); err == nil || !strings.HasPrefix(err.Error(), name+" buckets") {
+			t.Errorf("expected an error for invalid %s buckets, got %v", name, err)
+		}
+	}
+}
+
+func TestWrapHandler(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	handler := monitor.WrapHandler("/reports/{id}", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/reports/42", nil))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/reports/{id}", "status": "202"}); count != 1 {
+		t.Errorf("expected the request to be recorded with the supplied addr, got %d requests", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/reports/42"}); count != 0 {
+		t.Errorf("expected the request path not to be recorded, got %d requests", count)
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	buckets := ExponentialBuckets(0.01, 2, 5)
+	expected := []float64{0.01, 0.02, 0.04, 0.08, 0.16}
+	if len(buckets) != len(expected) {
+		t.Fatalf("expected buckets %v, got %v", expected, buckets)
+	}
+	for i := range expected {
+		if math.Abs(buckets[i]-expected[i]) > 1e-9 {
+			t.Errorf("expected buckets %v, got %v", expected, buckets)
+			break
+		}
+	}
+
+	if buckets := LinearBuckets(1, 2, 3); len(buckets) != 3 || buckets[0] != 1 || buckets[1] != 3 || buckets[2] != 5 {
+		t.Errorf("expected linear buckets [1 3 5], got %v", buckets)
+	}
+}
+
+func TestDefaultWebBuckets(t *testing.T) {
+	if err := validateBuckets("web", DefaultWebBuckets); err != nil {
+		t.Error(err)
+	}
+	if first, last := DefaultWebBuckets[0], DefaultWebBuckets[len(DefaultWebBuckets)-1]; first != 0.005 || last != 10 {
+		t.Errorf("expected buckets from 5ms to 10s, got %v to %v", first, last)
+	}
+	if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithBuckets(DefaultWebBuckets)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithNativeHistograms(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithNativeHistograms(1.1))
+	labelValues := []string{"HTTP/1.1", "200", "GET", "/", "false", ""}
+
+	monitor.collectTime(labelValues, "", time.Millisecond*200)
+	monitor.collectTime(labelValues, "", time.Second*3)
+	monitor.CollectDependencyTime("database", "sql", "ok", "SELECT", "db:5432", "false", "", 0.05)
+
+	for name, observer := range map[string]prometheus.Observer{
+		"request_seconds":            monitor.reqDuration.WithLabelValues(labelValues...),
+		"dependency_request_seconds": monitor.dependencyReqDuration.WithLabelValues("database", "sql", "ok", "SELECT", "db:5432", "false", ""),
+	} {
+		var metric dto.Metric
+		if err := observer.(prometheus.Histogram).Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		histogram := metric.GetHistogram()
+
+		if histogram.Schema == nil {
+			t.Errorf("expected %s to be a native histogram", name)
+		}
+		if len(histogram.GetBucket()) != 0 {
+			t.Errorf("expected %s to ignore the static buckets, got %v", name, histogram.GetBucket())
+		}
+		if histogram.GetSampleCount() == 0 || len(histogram.GetPositiveSpan()) == 0 {
+			t.Errorf("expected %s observations to be recorded in native buckets", name)
+		}
+	}
+}
+
+func TestWithNativeHistogramsInvalid(t *testing.T) {
+	if _, err := NewMonitor("v1.0.0", WithNativeHistograms(1)); err == nil {
+		t.Error("expected an error for a bucket factor not greater than 1")
+	}
+}
+
+func TestPrometheusUnmatchedRoute(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	handler := monitor.Prometheus(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown/%3Fpath?token=secret#top", nil))
+
+	labels := requestSecondsLabels(t, monitor)
+	if labels["addr"] != UnmatchedAddr {
+		t.Errorf("expected the addr label of an unmatched request to be %q, got %q", UnmatchedAddr, labels["addr"])
+	}
+}
+
+func TestEscapedQuestionMarkAddr(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/files/what?", func(w http.ResponseWriter, _ *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/files/what%3F?token=secret", nil))
+
+	labels := requestSecondsLabels(t, monitor)
+	if labels["addr"] != "/files/what?" {
+		t.Errorf("expected the addr label to keep the escaped question mark, got %q", labels["addr"])
+	}
+}
+
+func TestSubrouterAddr(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	handler := func(w http.ResponseWriter, _ *http.Request) {}
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.PathPrefix("/api/v1").Subrouter().HandleFunc("/users/{id}", handler)
+	r.PathPrefix("/api/v2").Subrouter().HandleFunc("/users/{id}", handler)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/users/1", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v2/users/1", nil))
+
+	for _, addr := range []string{"/api/v1/users/{id}", "/api/v2/users/{id}"} {
+		if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": addr}); count != 1 {
+			t.Errorf("expected 1 request on %s, got %d", addr, count)
+		}
+	}
+}
+
+func TestWithAddrPrefix(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithAddrPrefix("/admin"))
+
+	mounted := mux.NewRouter()
+	mounted.Use(monitor.Prometheus)
+	mounted.HandleFunc("/users/{id}", func(w http.ResponseWriter, _ *http.Request) {})
+	r := mux.NewRouter()
+	r.PathPrefix("/admin").Handler(http.StripPrefix("/admin", mounted))
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/users/1?page=2", nil))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/admin/users/{id}"}); count != 1 {
+		t.Errorf("expected 1 request on /admin/users/{id}, got %d", count)
+	}
+}
+
+func TestWithAddrPrefixInvalid(t *testing.T) {
+	for _, prefix := range []string{"", "admin", "/\xff"} {
+		if _, err := NewMonitor("v1.0.0", WithRegistry(prometheus.NewRegistry()), WithAddrPrefix(prefix)); err == nil {
+			t.Errorf("expected an error for the prefix %q", prefix)
+		}
+	}
+}
+
+func TestStrippedVariablePrefixAddr(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, _ *http.Request) {})
+	// stripTenant removes the tenant segment in front of the router, like a per-tenant mount point
+	stripTenant := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		segments := strings.SplitN(req.URL.Path, "/", 3)
+		http.StripPrefix("/"+segments[1], r).ServeHTTP(w, req)
+	})
+
+	for _, target := range []string{"/acme/users/1", "/globex/users/2", "/%FF/users/3"} {
+		stripTenant.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/users/{id}"}); count != 3 {
+		t.Errorf("expected the tenants to share the /users/{id} series, got %d requests", count)
+	}
+}
+
+func TestWithTrailingSlashTrimmed(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithTrailingSlashTrimmed())
+	handler := func(w http.ResponseWriter, _ *http.Request) {}
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users", handler)
+	r.HandleFunc("/users/", handler)
+	r.HandleFunc("/", handler)
+
+	for _, path := range []string{"/users", "/users/", "/"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/users"}); count != 2 {
+		t.Errorf("expected both forms to be recorded in the /users series, got %d requests", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/users/"}); count != 0 {
+		t.Errorf("expected no /users/ series, got %d requests", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/"}); count != 1 {
+		t.Errorf("expected the root path to be kept, got %d requests", count)
+	}
+}
+
+func TestTrimTrailingSlash(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/users/{id}/": "/users/{id}",
+		"/users//":     "/users",
+		"/users":       "/users",
+		"/":            "/",
+		"//":           "/",
+		"":             "",
+	} {
+		if trimmed := trimTrailingSlash(path); trimmed != expected {
+			t.Errorf("expected %q to become %q, got %q", path, expected, trimmed)
+		}
+	}
+}
+
+func TestWithSampleRate(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithSampleRate(0))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("sampled out"))
+	})
+
+	for i := 0; i < 100; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if total := testutil.ToFloat64(monitor.requestsTotal.WithLabelValues(http.MethodGet, "/")); total != 100 {
+		t.Errorf("expected all 100 requests to be counted, got %v", total)
+	}
+	output := scrape(t, registry, false)
+	if strings.Contains(output, "request_seconds") || strings.Contains(output, "response_size_bytes") {
+		t.Errorf("expected no request observation with a zero sample rate:\n%s", output)
+	}
+}
+
+func TestRequestsTotalLabels(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithSampleRate(0), WithUnknownMethodsFolded(),
+		WithLabelNames(map[string]string{"method": "verb", "addr": "handler"}))
+
+	handler := monitor.Prometheus(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PURGE", "/cache/1", nil))
+
+	metrics := muxmonitortest.CollectMatching(t, monitor.requestsTotal, prometheus.Labels{"verb": OtherMethod, "handler": UnmatchedAddr})
+	if len(metrics) != 1 || metrics[0].GetCounter().GetValue() != 1 {
+		t.Errorf("expected the request to be counted with the renamed labels, folded method and unmatched addr, got %v", metrics)
+	}
+}
+
+func TestSampleRateDisabledByDefault(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	if monitor.requestsTotal != nil {
+		t.Error("expected no requests_total counter without sampling")
+	}
+	if !monitor.sampled() {
+		t.Error("expected every request to be sampled by default")
+	}
+}
+
+func TestWithSampleRateInvalid(t *testing.T) {
+	for _, rate := range []float64{-0.1, 1.1} {
+		if _, err := NewMonitor("v1.0.0", WithSampleRate(rate)); err == nil {
+			t.Errorf("expected an error for sample rate %v", rate)
+		}
+	}
+}
+
+func TestMetricAccessors(t *testing.T) {
+	monitor, registry := newTestMonitor(t)
+
+	monitor.RequestDuration().WithLabelValues("HTTP/1.1", "200", "GET", "/warm", "false", "").Observe(0.2)
+	monitor.ResponseSize().WithLabelValues("HTTP/1.1", "200", "GET", "/warm", "false", "").Add(10)
+	monitor.DependencyUp().WithLabelValues("database").Set(1)
+	monitor.DependencyRequestDuration().WithLabelValues("database", "sql", "ok", "SELECT", "db:5432", "false", "")
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/warm"}); count != 1 {
+		t.Errorf("expected the observation made through the accessor, got %d", count)
+	}
+
+	output := scrape(t, registry, false)
+	for _, expected := range []string{
+		`response_size_bytes{addr="/warm",errorMessage="",isError="false",method="GET",status="200",type="HTTP/1.1"} 10`,
+		`dependency_up{name="database"} 1`,
+		`dependency_request_seconds_count{addr="db:5432",errorMessage="",isError="false",method="SELECT",name="database",status="ok",type="sql"} 0`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestSkipRouteNamePrefix(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {}).Name(DefaultSkipRouteNamePrefix + "health")
+	r.HandleFunc("/users", func(w http.ResponseWriter, _ *http.Request) {}).Name("users")
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/health"}); count != 0 {
+		t.Errorf("expected the marked route not to be instrumented, got %d requests", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/users"}); count != 1 {
+		t.Errorf("expected the unmarked route to be instrumented, got %d requests", count)
+	}
+}
+
+func TestWithSkipRouteNamePrefix(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithSkipRouteNamePrefix("internal."))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/debug", func(w http.ResponseWriter, _ *http.Request) {}).Name("internal.debug")
+	r.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {}).Name(DefaultSkipRouteNamePrefix + "health")
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/debug", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/debug"}); count != 0 {
+		t.Errorf("expected the route marked with the custom prefix not to be instrumented, got %d requests", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/health"}); count != 1 {
+		t.Errorf("expected the default prefix to be replaced, got %d requests", count)
+	}
+}
+
+func TestNewNoop(t *testing.T) {
+	monitor := NewNoop()
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/users", nil))
+	monitor.CollectDependencyTime("database", "sql", "200", "SELECT", "users", "false", "", 0.1)
+	monitor.AddDependencyChecker(&constantChecker{name: "database", status: DOWN}, time.Millisecond)
+
+	if recorder.Code != http.StatusCreated {
+		t.Errorf("expected the request to pass through, got status %d", recorder.Code)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{}); count != 0 {
+		t.Errorf("expected no request recorded, got %d", count)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.dependencyReqDuration, prometheus.Labels{}); count != 0 {
+		t.Errorf("expected no dependency request recorded, got %d", count)
+	}
+	if err := monitor.WaitForDependencies(context.Background()); err != nil {
+		t.Errorf("expected no dependency to be waited for, got %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := muxmonitortest.GaugeValue(t, monitor.dependencyUP, prometheus.Labels{"name": "database"}); ok {
+		t.Error("expected the dependency checker not to run")
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	monitor, _ := newTestMonitor(t, WithClock(func() time.Time { return now }))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users", func(w http.ResponseWriter, _ *http.Request) {
+		now = now.Add(250 * time.Millisecond)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	var metric dto.Metric
+	histogram := monitor.reqDuration.WithLabelValues("HTTP/1.1", "200", "GET", "/users", "false", "").(prometheus.Histogram)
+	if err := histogram.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	if sum := metric.GetHistogram().GetSampleSum(); sum != 0.25 {
+		t.Errorf("expected the request to be observed taking exactly 0.25s, got %v", sum)
+	}
+
+	monitor.check(context.Background(), &constantChecker{name: "database", status: UP})
+	if timestamp := testutil.ToFloat64(monitor.dependencyLastCheck.WithLabelValues("database")); math.Abs(timestamp-1600000000.25) > 1e-6 {
+		t.Errorf("expected the check to be timestamped by the clock, got %f", timestamp)
+	}
+}
+
+func TestWithTimeToFirstByte(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	monitor, _ := newTestMonitor(t, WithTimeToFirstByte(), WithClock(func() time.Time { return now }))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/stream", func(w http.ResponseWriter, _ *http.Request) {
+		now = now.Add(100 * time.Millisecond)
+		_, _ = w.Write([]byte("first"))
+		now = now.Add(time.Second)
+		_, _ = w.Write([]byte("second"))
+	})
+	r.HandleFunc("/empty", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stream", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/empty", nil))
+
+	var metric dto.Metric
+	if err := monitor.timeToFirstByte.WithLabelValues("GET", "/stream").(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	if sum := metric.GetHistogram().GetSampleSum(); sum != 0.1 {
+		t.Errorf("expected a time to first byte of 0.1s, got %v", sum)
+	}
+	if count := sampleCount(t, monitor.timeToFirstByte, "GET", "/empty"); count != 0 {
+		t.Errorf("expected no observation for a response without body, got %d", count)
+	}
+}
+
+func TestCanceledRequest(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+
+	labels := prometheus.Labels{"addr": "/slow", "status": strconv.Itoa(StatusClientClosedRequest), "isError": "true"}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, labels); count != 1 {
+		t.Errorf("expected the canceled request to be recorded with status %d, got %d requests", StatusClientClosedRequest, count)
+	}
+}
+
+func TestDeadlineExceededRequest(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusGatewayTimeout)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/slow", "status": "504"}); count != 1 {
+		t.Errorf("expected a request timing out to keep the status set by the handler, got %d requests", count)
+	}
+}
+
+func TestWithMilliseconds(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	monitor, registry := newTestMonitor(t, WithMilliseconds(), WithClock(func() time.Time { return now }))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		now = now.Add(1500 * time.Millisecond)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	monitor.CollectDependencyTime("database", "sql", "200", "SELECT", "users", "false", "", 0.2)
+
+	output := scrape(t, registry, false)
+	for _, expected := range []string{
+		`request_milliseconds_sum{addr="/slow",errorMessage="",isError="false",method="GET",status="200",type="HTTP/1.1"} 1500`,
+		`request_milliseconds_bucket{addr="/slow",errorMessage="",isError="false",method="GET",status="200",type="HTTP/1.1",le="1500"} 1`,
+		`request_milliseconds_bucket{addr="/slow",errorMessage="",isError="false",method="GET",status="200",type="HTTP/1.1",le="300"} 0`,
+		`dependency_request_seconds_bucket{addr="users",errorMessage="",isError="false",method="SELECT",name="database",status="200",type="sql",le="0.3"} 1`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in output:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "# TYPE request_seconds") {
+		t.Errorf("expected no request_seconds histogram in milliseconds mode:\n%s", output)
+	}
+}
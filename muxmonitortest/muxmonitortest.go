@@ -0,0 +1,109 @@
+// Package muxmonitortest eases assertions on the metrics recorded by a mux-monitor Monitor in tests of the handlers
+// it instruments. Its functions collect the metric vectors exposed by the monitor, whatever their name, and sum the
+// series whose labels include the given ones:
+//
+//	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRegistry(prometheus.NewRegistry()))
+//	// ... serve requests through monitor.Prometheus
+//	count := muxmonitortest.SampleCount(t, monitor.RequestDuration(), prometheus.Labels{"addr": "/users/{id}"})
+//
+// The test fails when a given label isn't a label of the collected metrics, e.g. after renaming it with
+// WithLabelNames, instead of matching no series.
+package muxmonitortest
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// SampleCount returns the number of observations of the histograms whose labels include the given ones, e.g. the
+// requests observed by monitor.RequestDuration() with prometheus.Labels{"addr": "/users/{id}", "status": "200"}
+func SampleCount(t testing.TB, collector prometheus.Collector, labels prometheus.Labels) uint64 {
+	t.Helper()
+
+	var count uint64
+	for _, metric := range CollectMatching(t, collector, labels) {
+		count += metric.GetHistogram().GetSampleCount()
+	}
+	return count
+}
+
+// CounterSum returns the sum of the counters whose labels include the given ones, e.g. the response sizes counted by
+// monitor.ResponseSize()
+func CounterSum(t testing.TB, collector prometheus.Collector, labels prometheus.Labels) float64 {
+	t.Helper()
+
+	var sum float64
+	for _, metric := range CollectMatching(t, collector, labels) {
+		sum += metric.GetCounter().GetValue()
+	}
+	return sum
+}
+
+// GaugeValue returns the value of the gauge whose labels include the given ones, e.g. the dependency_up value of
+// monitor.DependencyUp() with prometheus.Labels{"name": "database"}, and whether it was recorded
+func GaugeValue(t testing.TB, collector prometheus.Collector, labels prometheus.Labels) (float64, bool) {
+	t.Helper()
+
+	metrics := CollectMatching(t, collector, labels)
+	if len(metrics) == 0 {
+		return 0, false
+	}
+	return metrics[0].GetGauge().GetValue(), true
+}
+
+// CollectMatching collects the metrics of collector whose labels include the given labels, failing the test when a
+// metric can't be written or lacks one of the given label names
+func CollectMatching(t testing.TB, collector prometheus.Collector, labels prometheus.Labels) []*dto.Metric {
+	t.Helper()
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(metrics)
+		close(metrics)
+	}()
+
+	var collected []*dto.Metric
+	for metric := range metrics {
+		var written dto.Metric
+		if err := metric.Write(&written); err != nil {
+			t.Errorf("writing metric %s: %v", metric.Desc(), err)
+			continue
+		}
+		collected = append(collected, &written)
+	}
+
+	var matching []*dto.Metric
+	for _, metric := range collected {
+		matches, missing := matchLabels(metric, labels)
+		if missing != "" {
+			t.Fatalf("no %q label on metric %v", missing, metric.GetLabel())
+		}
+		if matches {
+			matching = append(matching, metric)
+		}
+	}
+	return matching
+}
+
+// matchLabels reports whether the metric labels include the given labels, or the name of a given label the metric
+// doesn't have
+func matchLabels(metric *dto.Metric, labels prometheus.Labels) (bool, string) {
+	values := make(map[string]string, len(metric.GetLabel()))
+	for _, pair := range metric.GetLabel() {
+		values[pair.GetName()] = pair.GetValue()
+	}
+
+	matches := true
+	for name, expected := range labels {
+		value, ok := values[name]
+		if !ok {
+			return false, name
+		}
+		if value != expected {
+			matches = false
+		}
+	}
+	return matches, ""
+}
@@ -0,0 +1,127 @@
+package muxmonitortest_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/gorilla/mux"
+	muxMonitor "github.com/labbsr0x/mux-monitor"
+	"github.com/labbsr0x/mux-monitor/muxmonitortest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newMonitor creates a monitor registering its metrics on a new registry
+func newMonitor(t *testing.T, opts ...muxMonitor.Option) *muxMonitor.Monitor {
+	t.Helper()
+
+	monitor, err := muxMonitor.NewMonitor("v1.0.0", append([]muxMonitor.Option{muxMonitor.WithRegistry(prometheus.NewRegistry())}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return monitor
+}
+
+// serveUsers serves requests to /users/1, /users/2 and the missing /users/0 through the monitor
+func serveUsers(monitor *muxMonitor.Monitor) {
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("user"))
+	})
+
+	for _, id := range []string{"1", "2", "0"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/"+id, nil))
+	}
+}
+
+// fatalRecorder is a testing.TB recording the failures of a helper instead of failing the test
+type fatalRecorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *fatalRecorder) Helper() {}
+
+func (r *fatalRecorder) Errorf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+}
+
+func (r *fatalRecorder) Fatalf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func TestRequestHelpers(t *testing.T) {
+	monitor := newMonitor(t)
+	serveUsers(monitor)
+
+	for _, test := range []struct {
+		labels   prometheus.Labels
+		expected uint64
+	}{
+		{labels: prometheus.Labels{"addr": "/users/{id}"}, expected: 3},
+		{labels: prometheus.Labels{"addr": "/users/{id}", "status": "200"}, expected: 2},
+		{labels: prometheus.Labels{"addr": "/users/{id}", "status": "404"}, expected: 1},
+		{labels: prometheus.Labels{"addr": "/other"}, expected: 0},
+	} {
+		if count := muxmonitortest.SampleCount(t, monitor.RequestDuration(), test.labels); count != test.expected {
+			t.Errorf("expected %d requests matching %v, got %d", test.expected, test.labels, count)
+		}
+	}
+
+	if size := muxmonitortest.CounterSum(t, monitor.ResponseSize(), prometheus.Labels{"addr": "/users/{id}"}); size != 8 {
+		t.Errorf("expected 8 bytes, got %v", size)
+	}
+}
+
+func TestRequestHelpersWithMilliseconds(t *testing.T) {
+	monitor := newMonitor(t, muxMonitor.WithMilliseconds())
+	serveUsers(monitor)
+
+	if count := muxmonitortest.SampleCount(t, monitor.RequestDuration(), prometheus.Labels{"addr": "/users/{id}"}); count != 3 {
+		t.Errorf("expected 3 requests on request_milliseconds, got %d", count)
+	}
+}
+
+func TestMissingLabelFails(t *testing.T) {
+	monitor := newMonitor(t, muxMonitor.WithLabelNames(map[string]string{"addr": "handler"}))
+	serveUsers(monitor)
+
+	recorder := &fatalRecorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		muxmonitortest.SampleCount(recorder, monitor.RequestDuration(), prometheus.Labels{"addr": "/users/{id}"})
+	}()
+	<-done
+
+	if recorder.failure == "" {
+		t.Error("expected the renamed addr label to fail the test")
+	}
+}
+
+func TestDependencyHelpers(t *testing.T) {
+	monitor := newMonitor(t)
+
+	if _, ok := muxmonitortest.GaugeValue(t, monitor.DependencyUp(), prometheus.Labels{"name": "database"}); ok {
+		t.Error("expected no dependency_up value before the first check")
+	}
+
+	monitor.SetDependencyStatus("database", muxMonitor.UP)
+	if value, ok := muxmonitortest.GaugeValue(t, monitor.DependencyUp(), prometheus.Labels{"name": "database"}); !ok || value != 1 {
+		t.Errorf("expected the database to be up, got %v", value)
+	}
+
+	monitor.CollectDependencyTime("database", "sql", "ok", "SELECT", "db:5432", "false", "", 0.1)
+	monitor.CollectDependencyTime("database", "sql", "error", "SELECT", "db:5432", "true", "timeout", 3)
+	if count := muxmonitortest.SampleCount(t, monitor.DependencyRequestDuration(), prometheus.Labels{"name": "database", "isError": "true"}); count != 1 {
+		t.Errorf("expected 1 failed dependency request, got %d", count)
+	}
+}
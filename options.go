@@ -0,0 +1,635 @@
+package mux_monitor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// Option configures optional behaviors of a Monitor
+type Option func(m *Monitor) error
+
+// WithErrorMessageKey sets the request header holding the error message of a request.
+// It defaults to DefaultErrorMessageKey, which is also used when errorMessageKey is blank.
+func WithErrorMessageKey(errorMessageKey string) Option {
+	return func(m *Monitor) error {
+		if strings.TrimSpace(errorMessageKey) == "" {
+			errorMessageKey = DefaultErrorMessageKey
+		}
+		m.errorMessageKey = errorMessageKey
+		return nil
+	}
+}
+
+// validateBuckets checks that histogram buckets are positive and strictly increasing
+func validateBuckets(name string, buckets []float64) error {
+	for i, bucket := range buckets {
+		if !(bucket > 0) {
+			return fmt.Errorf("%s buckets must be positive, got %v at index %d", name, bucket, i)
+		}
+		if i > 0 && bucket <= buckets[i-1] {
+			return fmt.Errorf("%s buckets must be strictly increasing, got %v after %v at index %d", name, bucket, buckets[i-1], i)
+		}
+	}
+	return nil
+}
+
+// WithBuckets sets the buckets of the request_seconds and dependency_request_seconds histograms.
+// It defaults to DefaultBuckets, which is also used when buckets is nil, or to DefaultMillisecondBuckets with
+// WithMilliseconds, in which case the buckets are in milliseconds.
+func WithBuckets(buckets []float64) Option {
+	return func(m *Monitor) error {
+		if err := validateBuckets("request", buckets); err != nil {
+			return err
+		}
+		m.buckets = buckets
+		return nil
+	}
+}
+
+// WithRegistry registers the monitor metrics on registerer instead of prometheus.DefaultRegisterer
+func WithRegistry(registerer prometheus.Registerer) Option {
+	return func(m *Monitor) error {
+		if registerer == nil {
+			return errors.New("registerer must not be nil")
+		}
+		m.registerer = registerer
+		return nil
+	}
+}
+
+// WithTraceIDFromRequest attaches the trace ID extracted from each request by the function, e.g. TraceIDFromHeader,
+// as an exemplar to its request duration observation. No exemplar is attached when it returns an empty string.
+func WithTraceIDFromRequest(traceID func(r *http.Request) string) Option {
+	return func(m *Monitor) error {
+		if traceID == nil {
+			return errors.New("trace ID function must not be nil")
+		}
+		m.traceIDFromRequest = traceID
+		return nil
+	}
+}
+
+// WithIsStatusError sets the predicate deciding whether a response status code is reported as an error,
+// replacing the default IsStatusError
+func WithIsStatusError(isStatusError func(statusCode int) bool) Option {
+	return func(m *Monitor) error {
+		if isStatusError == nil {
+			return errors.New("status error predicate must not be nil")
+		}
+		m.IsStatusError = isStatusError
+		return nil
+	}
+}
+
+// WithRouteAvailability records the http_route_availability gauge, computed per route from the ratio of
+// successful requests over a rolling window of the given size, at least AvailabilityMinWindow. The gauge is updated
+// in the background until the monitor is closed.
+func WithRouteAvailability(window time.Duration) Option {
+	return func(m *Monitor) error {
+		if window < AvailabilityMinWindow {
+			return fmt.Errorf("route availability window must be at least %v", AvailabilityMinWindow)
+		}
+		m.availabilityWindow = window
+		return nil
+	}
+}
+
+// WithDependencyVersions records the dependency_versions_info gauge with the version of each given component,
+// e.g. the versions of critical libraries linked into the application
+func WithDependencyVersions(versions map[string]string) Option {
+	return func(m *Monitor) error {
+		m.dependencyVersions = make(map[string]string, len(versions))
+		for component, version := range versions {
+			m.dependencyVersions[component] = version
+		}
+		return nil
+	}
+}
+
+// WithConditionalHits records the http_conditional_hits_total counter, counting per route the requests
+// answered with 304 Not Modified
+func WithConditionalHits() Option {
+	return func(m *Monitor) error {
+		m.conditionalHitsEnabled = true
+		return nil
+	}
+}
+
+// WithNamespace prefixes every metric name with the given namespace, e.g. myteam_request_seconds
+func WithNamespace(namespace string) Option {
+	return func(m *Monitor) error {
+		if namespace != "" && !model.IsValidMetricName(model.LabelValue(namespace)) {
+			return fmt.Errorf("invalid metric namespace %q", namespace)
+		}
+		m.namespace = namespace
+		return nil
+	}
+}
+
+// WithSubsystem prefixes every metric name with the given subsystem, after the namespace if any,
+// e.g. myteam_myservice_request_seconds
+func WithSubsystem(subsystem string) Option {
+	return func(m *Monitor) error {
+		if subsystem != "" && !model.IsValidMetricName(model.LabelValue(subsystem)) {
+			return fmt.Errorf("invalid metric subsystem %q", subsystem)
+		}
+		m.subsystem = subsystem
+		return nil
+	}
+}
+
+// WithConstLabels adds the given constant labels to every metric of the monitor, e.g. the region or
+// environment the application is deployed to
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(m *Monitor) error {
+		m.constLabels = make(prometheus.Labels, len(labels))
+		for name, value := range labels {
+			if !model.LabelName(name).IsValid() {
+				return fmt.Errorf("invalid constant label name %q", name)
+			}
+			m.constLabels[name] = value
+		}
+		return nil
+	}
+}
+
+// WithErrorMessageMaxLength truncates the errorMessage label to maxLength characters, bounding its cardinality.
+// It defaults to DefaultErrorMessageMaxLength, and a zero maxLength disables the truncation.
+func WithErrorMessageMaxLength(maxLength int) Option {
+	return func(m *Monitor) error {
+		if maxLength < 0 {
+			return errors.New("error message max length must not be negative")
+		}
+		m.errorMessageMaxLength = maxLength
+		return nil
+	}
+}
+
+// WithErrorMessageSanitizer applies sanitize to error messages before they become the errorMessage label,
+// e.g. to strip request IDs or map messages to a bounded set of values. Truncation applies after sanitizing.
+func WithErrorMessageSanitizer(sanitize func(errorMessage string) string) Option {
+	return func(m *Monitor) error {
+		m.errorMessageSanitizer = sanitize
+		return nil
+	}
+}
+
+// WithoutErrorMessageLabel removes the errorMessage label from the request metrics
+func WithoutErrorMessageLabel() Option {
+	return func(m *Monitor) error {
+		m.errorMessageLabelDisabled = true
+		return nil
+	}
+}
+
+// WithOverflowHistogram observes requests taking longer than threshold into the request_overflow_seconds histogram
+// with the given coarse buckets, instead of request_seconds. It gives visibility into the distribution of very slow
+// requests without adding buckets to the fast path, and the total of requests becomes the sum of both histograms.
+func WithOverflowHistogram(threshold time.Duration, buckets []float64) Option {
+	return func(m *Monitor) error {
+		if threshold <= 0 {
+			return errors.New("overflow threshold must be positive")
+		}
+		if len(buckets) == 0 {
+			return errors.New("overflow buckets must not be empty")
+		}
+		if err := validateBuckets("overflow", buckets); err != nil {
+			return err
+		}
+		m.overflowThreshold = threshold
+		m.overflowBuckets = buckets
+		return nil
+	}
+}
+
+// WithPathVarsLabel adds the path_vars label to the request metrics, holding the number of variables matched by the route
+func WithPathVarsLabel() Option {
+	return func(m *Monitor) error {
+		m.pathVarsLabelEnabled = true
+		return nil
+	}
+}
+
+// WithSchemeLabel adds the scheme label to the request metrics, https for requests received over TLS and http
+// otherwise
+func WithSchemeLabel() Option {
+	return func(m *Monitor) error {
+		m.schemeLabelEnabled = true
+		return nil
+	}
+}
+
+// WithTypeLabel sets the function returning the type label value of each request, e.g. to tell API from static
+// traffic apart, instead of the request protocol. Values are bounded like the ones of WithExtraLabels.
+func WithTypeLabel(requestType func(r *http.Request) string) Option {
+	return func(m *Monitor) error {
+		if requestType == nil {
+			return errors.New("request type function must not be nil")
+		}
+		m.requestType = requestType
+		m.requestTypeLimit = newLabelValueLimit(ExtraLabelMaxValues)
+		return nil
+	}
+}
+
+// WithContentTypeLabel adds the content_type label to the request metrics, holding the media type of the
+// Content-Type response header without parameters, e.g. application/json for "application/json; charset=utf-8",
+// or UnknownContentType when the response has none
+func WithContentTypeLabel() Option {
+	return func(m *Monitor) error {
+		m.contentTypeLabelEnabled = true
+		return nil
+	}
+}
+
+// WithRetryLabel adds the retry label to the request metrics, true for requests carrying the header, e.g.
+// Idempotency-Key, and accepted by isRetry, and false otherwise. When isRetry is nil, every request carrying the
+// header is a retry.
+func WithRetryLabel(header string, isRetry func(r *http.Request) bool) Option {
+	return func(m *Monitor) error {
+		if strings.TrimSpace(header) == "" {
+			return errors.New("retry header must not be empty")
+		}
+		m.retryHeader = header
+		m.isRetry = isRetry
+		return nil
+	}
+}
+
+// WithSlowRequestThreshold records the slow_requests_total counter, counting the requests whose duration exceeds the
+// threshold, e.g. the latency objective of the service
+func WithSlowRequestThreshold(threshold time.Duration) Option {
+	return func(m *Monitor) error {
+		if threshold <= 0 {
+			return errors.New("slow request threshold must be positive")
+		}
+		m.slowRequestThreshold = threshold
+		return nil
+	}
+}
+
+// WithResponsesWithoutBody records the responses_without_body_total counter, counting the requests whose handler
+// returned without calling WriteHeader or Write, e.g. hijacked connections, which are otherwise reported as 200 OK
+func WithResponsesWithoutBody() Option {
+	return func(m *Monitor) error {
+		m.responsesWithoutBodyEnabled = true
+		return nil
+	}
+}
+
+// WithHTTP2ConcurrentStreams records the http2_concurrent_streams histogram, observing for each HTTP/2 request how
+// many streams its connection is serving concurrently. It requires the monitor ConnContext to be set on the
+// http.Server. When buckets is nil, DefaultStreamBuckets is used.
+func WithHTTP2ConcurrentStreams(buckets []float64) Option {
+	return func(m *Monitor) error {
+		if buckets == nil {
+			buckets = DefaultStreamBuckets
+		}
+		if err := validateBuckets("stream", buckets); err != nil {
+			return err
+		}
+		m.streamBuckets = buckets
+		return nil
+	}
+}
+
+// WithLatencyClasses records the request_latency_class_total counter, classifying each request as fast, normal or slow.
+// Requests faster than the first threshold are fast, requests as slow as the last threshold are slow, and with two
+// thresholds the requests in between are normal.
+func WithLatencyClasses(thresholds []time.Duration) Option {
+	return func(m *Monitor) error {
+		if len(thresholds) != 1 && len(thresholds) != 2 {
+			return errors.New("latency classes require one or two thresholds")
+		}
+		if thresholds[0] <= 0 {
+			return errors.New("latency class thresholds must be positive")
+		}
+		if len(thresholds) == 2 && thresholds[1] <= thresholds[0] {
+			return errors.New("latency class thresholds must be increasing")
+		}
+		m.latencyThresholds = thresholds
+		return nil
+	}
+}
+
+// WithTopErrors keeps track of the most frequent error messages, available through TopErrors, without the
+// cardinality cost of the errorMessage label. Memory is bounded by tracking at most capacity messages.
+func WithTopErrors(capacity int) Option {
+	return func(m *Monitor) error {
+		if capacity <= 0 {
+			return errors.New("top errors capacity must be positive")
+		}
+		m.topErrors = newTopErrors(capacity)
+		return nil
+	}
+}
+
+// WithUnknownMethodsFolded reports requests with a method outside KnownMethods with the OTHER method label value,
+// protecting the metrics from the cardinality of crafted request methods
+func WithUnknownMethodsFolded() Option {
+	return func(m *Monitor) error {
+		m.unknownMethodsFolded = true
+		return nil
+	}
+}
+
+// WithPreflightRequestsFolded reports CORS preflight requests, OPTIONS requests with the
+// Access-Control-Request-Method header, with the PREFLIGHT method label value
+func WithPreflightRequestsFolded() Option {
+	return func(m *Monitor) error {
+		m.preflightFolded = true
+		return nil
+	}
+}
+
+// WithoutPreflightRequests skips the instrumentation of CORS preflight requests, OPTIONS requests with the
+// Access-Control-Request-Method header
+func WithoutPreflightRequests() Option {
+	return func(m *Monitor) error {
+		m.preflightExcluded = true
+		return nil
+	}
+}
+
+// WithoutApplicationInfo skips the application_info gauge, e.g. when the collector already labels the series with the
+// application version. The version passed to the constructor is still required.
+func WithoutApplicationInfo() Option {
+	return func(m *Monitor) error {
+		m.applicationInfoDisabled = true
+		return nil
+	}
+}
+
+// WithoutMethodLabel removes the method label from every metric of the HTTP requests, so that crafted request
+// methods can't increase their cardinality
+func WithoutMethodLabel() Option {
+	return func(m *Monitor) error {
+		m.methodLabelDisabled = true
+		return nil
+	}
+}
+
+// WithNativeHistograms records request_seconds and dependency_request_seconds as native histograms, whose buckets
+// are chosen dynamically with a growth factor of bucketFactor between consecutive buckets (e.g. 1.1). The static
+// buckets are ignored for those histograms. Native histograms are only exposed in the protobuf format.
+func WithNativeHistograms(bucketFactor float64) Option {
+	return func(m *Monitor) error {
+		if bucketFactor <= 1 {
+			return errors.New("native histogram bucket factor must be greater than 1")
+		}
+		m.nativeHistogramBucketFactor = bucketFactor
+		return nil
+	}
+}
+
+// WithSampleRate records the request_seconds and response_size_bytes metrics for only a fraction of the requests,
+// between 0 and 1, reducing the instrumentation overhead of high throughput services. All requests are still
+// counted by the requests_total counter, so request rates stay accurate.
+func WithSampleRate(rate float64) Option {
+	return func(m *Monitor) error {
+		if rate < 0 || rate > 1 {
+			return errors.New("sample rate must be between 0 and 1")
+		}
+		m.sampleRate = rate
+		return nil
+	}
+}
+
+// WithSkipRouteNamePrefix sets the name prefix marking routes whose requests are not instrumented, replacing
+// DefaultSkipRouteNamePrefix. An empty prefix instruments every route.
+func WithSkipRouteNamePrefix(prefix string) Option {
+	return func(m *Monitor) error {
+		m.skipRouteNamePrefix = prefix
+		return nil
+	}
+}
+
+// WithLabelNames renames labels of the request metrics, mapping their default names to new ones, e.g.
+// {"status": "code", "addr": "handler", "method": "verb"}. Renamed labels keep their values.
+func WithLabelNames(names map[string]string) Option {
+	return func(m *Monitor) error {
+		m.labelNames = make(map[string]string, len(names))
+		for name, renamed := range names {
+			if !isDefaultRequestLabelName(name) {
+				return fmt.Errorf("unknown request label %q", name)
+			}
+			if !model.LabelName(renamed).IsValid() || renamed == "le" {
+				return fmt.Errorf("invalid name %q for request label %q", renamed, name)
+			}
+			m.labelNames[name] = renamed
+		}
+		return nil
+	}
+}
+
+// WithLabelCache caches the request_seconds and response_size_bytes series of each combination of label values, so
+// recording a request skips resolving them on the metric vectors. Series deleted from the vectors returned by
+// RequestDuration and ResponseSize are not evicted from the cache.
+func WithLabelCache() Option {
+	return func(m *Monitor) error {
+		m.labelCache = &labelCache{}
+		return nil
+	}
+}
+
+// WithDependencyCheckJitter randomly shifts each dependency check by up to the fraction of its checking period, in
+// either direction, so replicas started together don't check their dependencies at the same instant. The fraction
+// must be in [0, 1), and checks keep the configured period on average.
+func WithDependencyCheckJitter(fraction float64) Option {
+	return func(m *Monitor) error {
+		if fraction < 0 || fraction >= 1 {
+			return fmt.Errorf("dependency check jitter must be in [0, 1), got %v", fraction)
+		}
+		m.dependencyCheckJitter = fraction
+		return nil
+	}
+}
+
+// WithClock sets the function returning the current time, used to measure request and dependency check durations
+// and to timestamp the metrics, e.g. to observe exact durations in tests. It defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(m *Monitor) error {
+		if now == nil {
+			return errors.New("clock must not be nil")
+		}
+		m.now = now
+		return nil
+	}
+}
+
+// WithTimeToFirstByte enables the request_ttfb_seconds histogram, observing the time from receiving a request to the
+// first Write of its response body. Requests whose handler writes no body are not observed.
+func WithTimeToFirstByte() Option {
+	return func(m *Monitor) error {
+		m.ttfbEnabled = true
+		return nil
+	}
+}
+
+// WithQueryParamLabels adds a label to the request metrics for each of the given query parameters, named after the
+// parameter and holding its value, truncated to QueryParamMaxLength characters. Each label keeps at most
+// ExtraLabelMaxValues distinct values, folding later ones into OtherLabelValue. Other query parameters are ignored.
+func WithQueryParamLabels(params ...string) Option {
+	return func(m *Monitor) error {
+		for _, param := range params {
+			if !model.LabelName(param).IsValid() || param == "le" {
+				return fmt.Errorf("invalid query parameter label name %q", param)
+			}
+		}
+		m.queryParamLabels = params
+		m.queryParamLimits = make([]*labelValueLimit, len(params))
+		for i := range params {
+			m.queryParamLimits[i] = newLabelValueLimit(ExtraLabelMaxValues)
+		}
+		return nil
+	}
+}
+
+// WithExtraLabels adds the named labels to the request metrics, holding the values returned by the function for each
+// request in the same order, e.g. a tenant ID taken from the request context. Values are truncated to
+// ExtraLabelMaxLength characters, and each label keeps at most ExtraLabelMaxValues distinct values, folding later
+// ones into OtherLabelValue.
+func WithExtraLabels(names []string, values func(r *http.Request) []string) Option {
+	return func(m *Monitor) error {
+		if values == nil {
+			return errors.New("extra label values function must not be nil")
+		}
+		for _, name := range names {
+			if !model.LabelName(name).IsValid() || name == "le" {
+				return fmt.Errorf("invalid extra label name %q", name)
+			}
+		}
+		m.extraLabelNames = names
+		m.extraLabelValues = values
+		m.extraLabelLimits = make([]*labelValueLimit, len(names))
+		for i := range names {
+			m.extraLabelLimits[i] = newLabelValueLimit(ExtraLabelMaxValues)
+		}
+		return nil
+	}
+}
+
+// WithRecorder records the request_seconds, response_size_bytes, dependency_request_seconds and dependency_up
+// metrics on the recorder instead of Prometheus, e.g. to export them through OpenTelemetry. The other metrics are
+// still recorded on Prometheus.
+func WithRecorder(recorder Recorder) Option {
+	return func(m *Monitor) error {
+		m.recorder = recorder
+		return nil
+	}
+}
+
+// WithMilliseconds records the request histograms in milliseconds instead of seconds, renaming them accordingly,
+// e.g. request_milliseconds instead of request_seconds. The buckets set by WithBuckets are then in milliseconds, and
+// default to DefaultMillisecondBuckets. The dependency histograms are still recorded in seconds.
+func WithMilliseconds() Option {
+	return func(m *Monitor) error {
+		m.millisecondsEnabled = true
+		return nil
+	}
+}
+
+// WithDependencyCheckThresholds debounces the reported dependency statuses: a dependency reported UP is reported
+// DOWN only after failures consecutive failed checks, and UP again only after successes consecutive successful
+// checks, so transient failures don't flip dependency_up. Both thresholds default to 1.
+func WithDependencyCheckThresholds(failures, successes int) Option {
+	return func(m *Monitor) error {
+		if failures < 1 || successes < 1 {
+			return fmt.Errorf("dependency check thresholds must be at least 1, got %d failures and %d successes", failures, successes)
+		}
+		m.failureThreshold = failures
+		m.successThreshold = successes
+		return nil
+	}
+}
+
+// WithDependencyStateChangeHook calls the hook whenever the reported status of a dependency changes, with its previous
+// and new status, e.g. to log it. It isn't called for the first status of a dependency. The hook is called from the
+// checker goroutines, possibly concurrently, without any lock of the monitor held, so it may call back into it.
+func WithDependencyStateChangeHook(hook func(name string, previous, current DependencyStatus)) Option {
+	return func(m *Monitor) error {
+		if hook == nil {
+			return errors.New("dependency state change hook must not be nil")
+		}
+		m.dependencyStateChangeHook = hook
+		return nil
+	}
+}
+
+// WithResponseSizeHistogram records the response_size_bytes_histogram histogram, observing the size of each response
+// in addition to the response_size_bytes counter. When buckets is nil, DefaultSizeBuckets is used.
+func WithResponseSizeHistogram(buckets []float64) Option {
+	return func(m *Monitor) error {
+		if buckets == nil {
+			buckets = DefaultSizeBuckets
+		}
+		if err := validateBuckets("size", buckets); err != nil {
+			return err
+		}
+		m.sizeBuckets = buckets
+		return nil
+	}
+}
+
+// WithTrailingSlashTrimmed removes trailing slashes from the addr label values, after resolving the route templates,
+// so that e.g. requests to /users/ and /users are recorded in the same series
+func WithTrailingSlashTrimmed() Option {
+	return func(m *Monitor) error {
+		m.trailingSlashTrimmed = true
+		return nil
+	}
+}
+
+// WithAddrPrefix prepends the prefix to the addr label values of matched routes and patterns, for handlers mounted
+// under a prefix removed before they're reached, e.g. by http.StripPrefix("/admin", router). The prefix is constant,
+// so that prefixes varying per request, e.g. tenant IDs, don't increase the cardinality of the addr label.
+func WithAddrPrefix(prefix string) Option {
+	return func(m *Monitor) error {
+		if !strings.HasPrefix(prefix, "/") || !utf8.ValidString(prefix) {
+			return fmt.Errorf("invalid addr prefix %q", prefix)
+		}
+		m.addrPrefix = prefix
+		return nil
+	}
+}
+
+// WithExpectedStatusCodes excludes the status codes from the error classification of every request, e.g. 404 on
+// services where missing resources are part of the normal flow
+func WithExpectedStatusCodes(statusCodes ...int) Option {
+	return func(m *Monitor) error {
+		if m.expectedStatusCodes == nil {
+			m.expectedStatusCodes = make(map[int]bool, len(statusCodes))
+		}
+		for _, statusCode := range statusCodes {
+			m.expectedStatusCodes[statusCode] = true
+		}
+		return nil
+	}
+}
+
+// WithRouteExpectedStatusCodes excludes the status codes from the error classification of the requests matching the
+// route with the given name, e.g. r.HandleFunc("/cache/{key}", getCached).Name("cache") with a 404 cache miss
+func WithRouteExpectedStatusCodes(routeName string, statusCodes ...int) Option {
+	return func(m *Monitor) error {
+		if routeName == "" {
+			return errors.New("route name must not be empty")
+		}
+		if m.routeExpectedStatusCodes == nil {
+			m.routeExpectedStatusCodes = make(map[string]map[int]bool)
+		}
+		if m.routeExpectedStatusCodes[routeName] == nil {
+			m.routeExpectedStatusCodes[routeName] = make(map[int]bool, len(statusCodes))
+		}
+		for _, statusCode := range statusCodes {
+			m.routeExpectedStatusCodes[routeName][statusCode] = true
+		}
+		return nil
+	}
+}
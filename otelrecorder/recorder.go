@@ -0,0 +1,105 @@
+// Package otelrecorder records the metrics of a mux-monitor Monitor through an OpenTelemetry meter, as an
+// alternative to Prometheus scraping:
+//
+//	recorder, err := otelrecorder.New(otel.Meter("my-service"))
+//	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRecorder(recorder))
+package otelrecorder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	muxMonitor "github.com/labbsr0x/mux-monitor"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Recorder is a muxMonitor.Recorder recording on OpenTelemetry instruments named after the Prometheus metrics
+type Recorder struct {
+	reqDuration           metric.Float64Histogram
+	respSize              metric.Int64Counter
+	dependencyReqDuration metric.Float64Histogram
+
+	statusesMutex sync.Mutex
+	statuses      map[string]muxMonitor.DependencyStatus
+}
+
+// New creates a Recorder whose instruments are created by meter
+func New(meter metric.Meter) (*Recorder, error) {
+	recorder := &Recorder{statuses: make(map[string]muxMonitor.DependencyStatus)}
+
+	var err error
+	if recorder.reqDuration, err = meter.Float64Histogram("request_seconds",
+		metric.WithDescription("Duration in seconds of HTTP requests."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+
+	if recorder.respSize, err = meter.Int64Counter("response_size_bytes",
+		metric.WithDescription("Counts the size of each HTTP response"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+
+	if recorder.dependencyReqDuration, err = meter.Float64Histogram("dependency_request_seconds",
+		metric.WithDescription("Duration of dependency requests in seconds."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+
+	if _, err = meter.Int64ObservableGauge("dependency_up",
+		metric.WithDescription("Records if a dependency is up or down. 1 for up, 0 for down"),
+		metric.WithInt64Callback(recorder.observeStatuses)); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// RecordRequest records a request on the request_seconds histogram and the response_size_bytes counter
+func (r *Recorder) RecordRequest(ctx context.Context, labels prometheus.Labels, duration time.Duration, responseSize uint64) {
+	attributes := metric.WithAttributes(attributesOf(labels)...)
+	r.reqDuration.Record(ctx, duration.Seconds(), attributes)
+	r.respSize.Add(ctx, int64(responseSize), attributes)
+}
+
+// RecordDependencyRequest records a dependency request on the dependency_request_seconds histogram
+func (r *Recorder) RecordDependencyRequest(ctx context.Context, labels prometheus.Labels, duration time.Duration) {
+	r.dependencyReqDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attributesOf(labels)...))
+}
+
+// RecordDependencyStatus keeps the status of a dependency, observed by the dependency_up gauge
+func (r *Recorder) RecordDependencyStatus(_ context.Context, name string, status muxMonitor.DependencyStatus) {
+	r.statusesMutex.Lock()
+	defer r.statusesMutex.Unlock()
+	r.statuses[name] = status
+}
+
+// RemoveDependency drops the status of a dependency, which is no longer observed by the dependency_up gauge
+func (r *Recorder) RemoveDependency(_ context.Context, name string) {
+	r.statusesMutex.Lock()
+	defer r.statusesMutex.Unlock()
+	delete(r.statuses, name)
+}
+
+// observeStatuses observes the last status of each dependency on the dependency_up gauge
+func (r *Recorder) observeStatuses(_ context.Context, observer metric.Int64Observer) error {
+	r.statusesMutex.Lock()
+	defer r.statusesMutex.Unlock()
+
+	for name, status := range r.statuses {
+		observer.Observe(int64(status.GaugeValue()), metric.WithAttributes(attribute.String("name", name)))
+	}
+	return nil
+}
+
+// attributesOf converts labels to attributes
+func attributesOf(labels prometheus.Labels) []attribute.KeyValue {
+	attributes := make([]attribute.KeyValue, 0, len(labels))
+	for name, value := range labels {
+		attributes = append(attributes, attribute.String(name, value))
+	}
+	return attributes
+}
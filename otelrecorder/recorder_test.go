@@ -0,0 +1,149 @@
+package otelrecorder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	muxMonitor "github.com/labbsr0x/mux-monitor"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// upChecker is a DependencyChecker always reporting UP
+type upChecker struct{}
+
+func (upChecker) GetDependencyName() string {
+	return "database"
+}
+
+func (upChecker) Check() muxMonitor.DependencyStatus {
+	return muxMonitor.UP
+}
+
+// collect returns the metrics exported by the reader, by name
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	t.Helper()
+
+	var resourceMetrics metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &resourceMetrics); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := make(map[string]metricdata.Metrics)
+	for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+		for _, m := range scopeMetrics.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+	return metrics
+}
+
+func TestRecorder(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	recorder, err := New(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRegistry(registry), muxMonitor.WithRecorder(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/users/1", nil))
+
+	monitor.CollectDependencyTime("database", "sql", "200", "SELECT", "users", "false", "", 0.1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor.AddDependencyCheckerContext(ctx, upChecker{}, time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for _, ok := monitor.DependencyStatus("database"); !ok; _, ok = monitor.DependencyStatus("database") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the dependency to be checked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	metrics := collect(t, reader)
+
+	requests := metrics["request_seconds"].Data.(metricdata.Histogram[float64]).DataPoints
+	if len(requests) != 1 || requests[0].Count != 1 {
+		t.Fatalf("expected 1 request recorded, got %+v", requests)
+	}
+	for name, expected := range map[string]string{"addr": "/users/{id}", "method": "PUT", "status": "201", "isError": "false"} {
+		if value, ok := requests[0].Attributes.Value(attribute.Key(name)); !ok || value.AsString() != expected {
+			t.Errorf("expected the %s attribute to be %q, got %q", name, expected, value.AsString())
+		}
+	}
+
+	sizes := metrics["response_size_bytes"].Data.(metricdata.Sum[int64]).DataPoints
+	if len(sizes) != 1 || sizes[0].Value != int64(len("created")) {
+		t.Errorf("expected a response size of %d bytes, got %+v", len("created"), sizes)
+	}
+
+	dependencyRequests := map[string]metricdata.HistogramDataPoint[float64]{}
+	for _, point := range metrics["dependency_request_seconds"].Data.(metricdata.Histogram[float64]).DataPoints {
+		status, _ := point.Attributes.Value("status")
+		dependencyRequests[status.AsString()] = point
+	}
+	if request, ok := dependencyRequests["200"]; !ok || request.Sum != 0.1 {
+		t.Errorf("expected a 0.1s dependency request recorded, got %+v", dependencyRequests)
+	}
+	if check, ok := dependencyRequests["UP"]; !ok || check.Count == 0 {
+		t.Errorf("expected the dependency checks recorded, got %+v", dependencyRequests)
+	}
+
+	up := metrics["dependency_up"].Data.(metricdata.Gauge[int64]).DataPoints
+	if len(up) != 1 || up[0].Value != int64(muxMonitor.UP) {
+		t.Errorf("expected the database to be up, got %+v", up)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		switch family.GetName() {
+		case "request_seconds", "response_size_bytes", "dependency_request_seconds", "dependency_up":
+			t.Errorf("expected %s to be recorded only through the recorder", family.GetName())
+		}
+	}
+}
+
+func TestRecorderRemoveDependency(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	recorder, err := New(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor, err := muxMonitor.NewMonitor("v1.0.0", muxMonitor.WithRegistry(prometheus.NewRegistry()), muxMonitor.WithRecorder(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitor.SetDependencyStatus("database", muxMonitor.UP)
+	monitor.SetDependencyStatus("cache", muxMonitor.DOWN)
+	monitor.RemoveDependencyChecker("database")
+
+	up := collect(t, reader)["dependency_up"].Data.(metricdata.Gauge[int64]).DataPoints
+	if len(up) != 1 {
+		t.Fatalf("expected only the cache to be observed, got %+v", up)
+	}
+	if name, _ := up[0].Attributes.Value("name"); name.AsString() != "cache" {
+		t.Errorf("expected the removed database to be dropped, got %q", name.AsString())
+	}
+}
@@ -0,0 +1,80 @@
+package mux_monitor
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Recorder records the request and dependency measurements of a Monitor on a metrics backend other than Prometheus,
+// e.g. OpenTelemetry. The labels are the ones the Prometheus metrics would have, computed by the same middleware.
+type Recorder interface {
+	// RecordRequest records a request served by the middleware, as request_seconds and response_size_bytes
+	RecordRequest(ctx context.Context, labels prometheus.Labels, duration time.Duration, responseSize uint64)
+	// RecordDependencyRequest records a dependency request, as dependency_request_seconds
+	RecordDependencyRequest(ctx context.Context, labels prometheus.Labels, duration time.Duration)
+	// RecordDependencyStatus records the status reported by a dependency check, as dependency_up
+	RecordDependencyStatus(ctx context.Context, name string, status DependencyStatus)
+	// RemoveDependency drops the state kept for a dependency removed by RemoveDependencyChecker, so that its
+	// dependency_up series is no longer reported
+	RemoveDependency(ctx context.Context, name string)
+}
+
+// dependencyRequestLabelNames are the label names of the dependency_request_seconds histogram
+var dependencyRequestLabelNames = []string{"name", "type", "status", "method", "addr", "isError", "errorMessage"}
+
+// labelsOf pairs label names with their values
+func labelsOf(names, values []string) prometheus.Labels {
+	labels := make(prometheus.Labels, len(names))
+	for i, name := range names {
+		labels[name] = values[i]
+	}
+	return labels
+}
+
+// collectRequest records a request on the recorder, or on the request_seconds and response_size_bytes metrics
+func (m *Monitor) collectRequest(r *http.Request, labelValues []string, duration time.Duration, responseSize uint64) {
+	if m.recorder != nil {
+		m.recorder.RecordRequest(r.Context(), labelsOf(m.requestLabelNames(), labelValues), duration, responseSize)
+		return
+	}
+
+	traceID := ""
+	if m.traceIDFromRequest != nil {
+		traceID = m.traceIDFromRequest(r)
+	}
+	m.collectTime(labelValues, traceID, duration)
+	m.collectSize(labelValues, float64(responseSize))
+}
+
+// collectDependencyRequest records a dependency request on the recorder, or on the dependency_request_seconds metric
+func (m *Monitor) collectDependencyRequest(ctx context.Context, labelValues []string, duration time.Duration) {
+	if m.recorder != nil {
+		m.recorder.RecordDependencyRequest(ctx, labelsOf(dependencyRequestLabelNames, labelValues), duration)
+		return
+	}
+	m.dependencyReqDuration.WithLabelValues(labelValues...).Observe(duration.Seconds())
+}
+
+// removeDependency deletes the series of a removed dependency from the recorder and the dependency metrics
+func (m *Monitor) removeDependency(name string) {
+	if m.recorder != nil {
+		m.recorder.RemoveDependency(context.Background(), name)
+	}
+	m.dependencyUP.DeleteLabelValues(name)
+	m.dependencyLastCheck.DeleteLabelValues(name)
+	m.dependencyCheckTime.DeletePartialMatch(prometheus.Labels{"name": name})
+	m.dependencyReqDuration.DeletePartialMatch(prometheus.Labels{"name": name})
+	m.dependencyFailures.DeleteLabelValues(name)
+}
+
+// collectDependencyStatus records the status of a dependency on the recorder, or on the dependency_up metric
+func (m *Monitor) collectDependencyStatus(ctx context.Context, name string, status DependencyStatus) {
+	if m.recorder != nil {
+		m.recorder.RecordDependencyStatus(ctx, name, status)
+		return
+	}
+	m.dependencyUP.WithLabelValues(name).Set(status.GaugeValue())
+}
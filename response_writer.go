@@ -3,6 +3,7 @@ package mux_monitor
 import (
 	"net/http"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -10,42 +11,148 @@ import (
 // workaround to get status code on middleware
 type ResponseWriter struct {
 	http.ResponseWriter
-	started    time.Time
-	statusCode int
-	count      uint64
+	started time.Time
+	count   uint64
+	now     func() time.Time
+
+	// mutex guards the state below, as handlers streaming a response may write from several goroutines
+	mutex       sync.Mutex
+	statusCode  int
+	wroteHeader bool
+	written     bool
+	firstWrite  time.Time
 }
 
 func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return newResponseWriter(w, time.Now)
+}
+
+// newResponseWriter creates a ResponseWriter timed by the now clock
+func newResponseWriter(w http.ResponseWriter, now func() time.Time) *ResponseWriter {
 	// WriteHeader(int) is not called if our response implicitly returns 200 OK, so
 	// we default to that status code.
 	return &ResponseWriter{
 		ResponseWriter: w,
 		statusCode:     http.StatusOK,
-		started:        time.Now(),
+		started:        now(),
+		now:            now,
 	}
 }
 
 func (r *ResponseWriter) StatusCode() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	return r.statusCode
 }
 
 func (r *ResponseWriter) StatusCodeStr() string {
-	return strconv.Itoa(r.statusCode)
+	return strconv.Itoa(r.StatusCode())
+}
+
+// Written reports whether the handler called WriteHeader or Write. When it didn't, the reported
+// status code is the 200 OK default rather than a status set by the handler.
+func (r *ResponseWriter) Written() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.written
+}
+
+// TimeToFirstByte returns the time from the creation of the writer to the first Write call, and false when the
+// handler hasn't called Write
+func (r *ResponseWriter) TimeToFirstByte() (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.firstWrite.IsZero() {
+		return 0, false
+	}
+	return r.firstWrite.Sub(r.started), true
 }
 
 // Write returns underlying Write result, while counting data size
 func (r *ResponseWriter) Write(b []byte) (int, error) {
+	r.mutex.Lock()
+	if r.firstWrite.IsZero() {
+		r.firstWrite = r.now()
+	}
+	// writing the body sends the 200 OK header when no status was written before
+	r.wroteHeader = true
+	r.written = true
+	r.mutex.Unlock()
+
 	n, err := r.ResponseWriter.Write(b)
 	atomic.AddUint64(&r.count, uint64(n))
 	return n, err
 }
 
+// WriteHeader records the status code sent to the client. Only the first final status is recorded, since later
+// calls don't change the response, while informational statuses (1xx) are replaced by the final one.
 func (r *ResponseWriter) WriteHeader(code int) {
-	r.statusCode = code
+	r.mutex.Lock()
+	if !r.wroteHeader {
+		r.statusCode = code
+		r.wroteHeader = !isInformational(code)
+	}
+	r.written = true
+	r.mutex.Unlock()
+
 	r.ResponseWriter.WriteHeader(code)
 }
 
-// Count function return counted bytes
+// isInformational reports whether the status code is a 1xx status sent before the final one. 101 Switching
+// Protocols is final.
+func isInformational(code int) bool {
+	return code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+}
+
+// SetStatus overrides the status code reported to the metrics without writing a header, e.g. by a streaming handler
+// that fails after the response was already started with 200 OK. Handlers reach it by asserting the writer they
+// receive to *ResponseWriter.
+func (r *ResponseWriter) SetStatus(code int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.statusCode = code
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController and ReportStatus
+func (r *ResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// StatusSetter is implemented by writers recording the status of a response without writing it, like ResponseWriter
+type StatusSetter interface {
+	SetStatus(code int)
+}
+
+// ReportStatus reports the status code to every StatusSetter found by unwrapping w, and whether one was found.
+// Middlewares wrapping the writer after the monitor without forwarding WriteHeader, e.g. to buffer the response,
+// call it so the monitor records the status instead of the 200 OK default.
+func ReportStatus(w http.ResponseWriter, code int) bool {
+	reported := false
+	for w != nil {
+		if setter, ok := w.(StatusSetter); ok {
+			setter.SetStatus(code)
+			reported = true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = unwrapper.Unwrap()
+	}
+	return reported
+}
+
+// Count function return counted bytes. These are the bytes passed to this writer, so when a compression middleware
+// is registered after the monitor they are the compressed bytes sent on the wire, and when it's registered before
+// the monitor they are the uncompressed bytes written by the handler.
 func (r *ResponseWriter) Count() uint64 {
 	return atomic.LoadUint64(&r.count)
 }
+
+// Push implements http.Pusher, delegating to the underlying writer when it supports HTTP/2 server push
+func (r *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := r.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
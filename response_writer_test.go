@@ -0,0 +1,322 @@
+package mux_monitor
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/mux-monitor/muxmonitortest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestResponseWriterWritten(t *testing.T) {
+	for name, handler := range map[string]func(w http.ResponseWriter){
+		"WriteHeader": func(w http.ResponseWriter) { w.WriteHeader(http.StatusNoContent) },
+		"Write":       func(w http.ResponseWriter) { _, _ = w.Write([]byte("body")) },
+	} {
+		respWriter := NewResponseWriter(httptest.NewRecorder())
+		handler(respWriter)
+
+		if !respWriter.Written() {
+			t.Errorf("expected the response to be written after %s", name)
+		}
+	}
+
+	if NewResponseWriter(httptest.NewRecorder()).Written() {
+		t.Error("expected a new response not to be written")
+	}
+}
+
+func TestWithResponsesWithoutBody(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithResponsesWithoutBody())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/silent", func(w http.ResponseWriter, _ *http.Request) {})
+	r.HandleFunc("/talkative", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/silent", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/talkative", nil))
+
+	if count := testutil.ToFloat64(monitor.responsesWithoutBody.WithLabelValues(http.MethodGet, "/silent")); count != 1 {
+		t.Errorf("expected 1 response without body for the silent handler, got %v", count)
+	}
+	if count := testutil.ToFloat64(monitor.responsesWithoutBody.WithLabelValues(http.MethodGet, "/talkative")); count != 0 {
+		t.Errorf("expected no response without body for the talkative handler, got %v", count)
+	}
+}
+
+// gzipResponseWriter compresses the response body written by the handler
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
+
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		next.ServeHTTP(&gzipResponseWriter{ResponseWriter: w, writer: gz}, r)
+	})
+}
+
+func TestResponseSizeWithCompression(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	body := strings.Repeat("mux-monitor ", 1000)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus, gzipMiddleware)
+	r.HandleFunc("/compressed", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(body))
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/compressed", nil))
+
+	compressedSize := rec.Body.Len()
+	if compressedSize >= len(body) {
+		t.Fatalf("expected the response to be compressed, got %d bytes for a %d bytes body", compressedSize, len(body))
+	}
+
+	size := testutil.ToFloat64(monitor.respSize.WithLabelValues("HTTP/1.1", "200", "GET", "/compressed", "false", ""))
+	if size != float64(compressedSize) {
+		t.Errorf("expected the counted size to match the %d compressed bytes, got %v", compressedSize, size)
+	}
+}
+
+// pusherRecorder is a ResponseRecorder supporting HTTP/2 server push
+type pusherRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (p *pusherRecorder) Push(target string, _ *http.PushOptions) error {
+	p.pushed = append(p.pushed, target)
+	return nil
+}
+
+func TestResponseWriterPush(t *testing.T) {
+	recorder := &pusherRecorder{ResponseRecorder: httptest.NewRecorder()}
+	var respWriter http.ResponseWriter = NewResponseWriter(recorder)
+
+	pusher, ok := respWriter.(http.Pusher)
+	if !ok {
+		t.Fatal("expected ResponseWriter to implement http.Pusher")
+	}
+	if err := pusher.Push("/static/app.js", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.pushed) != 1 || recorder.pushed[0] != "/static/app.js" {
+		t.Errorf("expected the push to be forwarded, got %v", recorder.pushed)
+	}
+}
+
+func TestResponseWriterPushNotSupported(t *testing.T) {
+	respWriter := NewResponseWriter(httptest.NewRecorder())
+
+	if err := respWriter.Push("/static/app.js", nil); err != http.ErrNotSupported {
+		t.Errorf("expected http.ErrNotSupported, got %v", err)
+	}
+}
+
+func TestResponseSizeWithChunkedBody(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	chunk := strings.Repeat("x", 1024)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/stream", func(w http.ResponseWriter, _ *http.Request) {
+		for i := 0; i < 10; i++ {
+			_, _ = w.Write([]byte(chunk))
+		}
+	})
+
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentLength != -1 {
+		t.Fatalf("expected a chunked response with unknown content length, got %d", resp.ContentLength)
+	}
+	if size := muxmonitortest.CounterSum(t, monitor.respSize, prometheus.Labels{"addr": "/stream"}); size != float64(len(body)) {
+		t.Errorf("expected the counted size to be the %d bytes actually written, got %v", len(body), size)
+	}
+}
+
+func TestResponseWriterSetStatus(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/stream", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		w.(*ResponseWriter).SetStatus(http.StatusInternalServerError)
+	})
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected the status sent to the client to stay %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/stream", "status": "500", "isError": "true"}); count != 1 {
+		t.Errorf("expected the request to be recorded with the overridden status, got %d requests", count)
+	}
+}
+
+// bufferingWriter is a status-capturing writer that holds the status back instead of forwarding WriteHeader
+type bufferingWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *bufferingWriter) WriteHeader(code int) {
+	w.statusCode = code
+	ReportStatus(w, code)
+}
+
+func (w *bufferingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestReportStatusNestedWriter(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&bufferingWriter{ResponseWriter: w}, r)
+		})
+	})
+	r.HandleFunc("/missing", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("not found"))
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/missing", "status": "404"}); count != 1 {
+		t.Errorf("expected the status reported by the nested writer to be recorded, got %d requests", count)
+	}
+}
+
+func TestReportStatusWithoutSetter(t *testing.T) {
+	if ReportStatus(httptest.NewRecorder(), http.StatusNotFound) {
+		t.Error("expected no status setter to be found")
+	}
+}
+
+// discardWriter is an http.ResponseWriter discarding the response, safe for concurrent use
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardWriter) WriteHeader(int) {}
+
+func TestResponseWriterConcurrentWriteHeader(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	var wg sync.WaitGroup
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/stream", func(w http.ResponseWriter, _ *http.Request) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}()
+	})
+
+	r.ServeHTTP(&discardWriter{header: http.Header{}}, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	wg.Wait()
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/stream"}); count != 1 {
+		t.Errorf("expected 1 request recorded, got %d", count)
+	}
+}
+
+func TestResponseWriterFirstStatusWins(t *testing.T) {
+	for name, test := range map[string]struct {
+		handler  func(w http.ResponseWriter)
+		expected int
+	}{
+		"second WriteHeader": {func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusCreated},
+		"WriteHeader after Write": {func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte("body"))
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusOK},
+		"informational status": {func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusAccepted)
+		}, http.StatusAccepted},
+	} {
+		t.Run(name, func(t *testing.T) {
+			writer := NewResponseWriter(&discardWriter{header: http.Header{}})
+			test.handler(writer)
+			if status := writer.StatusCode(); status != test.expected {
+				t.Errorf("expected status %d, got %d", test.expected, status)
+			}
+		})
+	}
+}
+
+func TestWithResponseSizeHistogram(t *testing.T) {
+	monitor, registry := newTestMonitor(t, WithResponseSizeHistogram([]float64{100, 1000}))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/items/{size}", func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(mux.Vars(r)["size"])
+		_, _ = w.Write([]byte(strings.Repeat("x", size)))
+	})
+	for _, size := range []string{"10", "50", "500", "5000"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/"+size, nil))
+	}
+
+	output := scrape(t, registry, false)
+	for _, expected := range []string{
+		`response_size_bytes_histogram_bucket{addr="/items/{size}",errorMessage="",isError="false",method="GET",status="200",type="HTTP/1.1",le="100"} 2`,
+		`response_size_bytes_histogram_bucket{addr="/items/{size}",errorMessage="",isError="false",method="GET",status="200",type="HTTP/1.1",le="1000"} 3`,
+		`response_size_bytes_histogram_bucket{addr="/items/{size}",errorMessage="",isError="false",method="GET",status="200",type="HTTP/1.1",le="+Inf"} 4`,
+		`response_size_bytes{addr="/items/{size}",errorMessage="",isError="false",method="GET",status="200",type="HTTP/1.1"} 5560`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %s in output:\n%s", expected, output)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package mux_monitor
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PrometheusServeMux is the middleware for handlers routed by a net/http ServeMux instead of gorilla/mux. It wraps
+// the ServeMux, e.g. http.ListenAndServe(":8080", monitor.PrometheusServeMux(serveMux)), labeling the requests with
+// the path of the pattern they matched. Patterns are available from Go 1.23, and with older versions or requests
+// matching no pattern, UnmatchedAddr is used. Route markers and HTTP/2 concurrent streams are not supported.
+func (m *Monitor) PrometheusServeMux(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.noop || m.skipped(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		respWriter := newResponseWriter(w, m.now)
+
+		next.ServeHTTP(respWriter, r)
+
+		m.record(r, respWriter, m.addrLabel(m.patternPath(r)))
+	})
+}
+
+// patternPath returns the addr label value of a request routed by a ServeMux: the path of the matched pattern behind
+// the prefix set by WithAddrPrefix, or UnmatchedAddr when no pattern was matched
+func (m *Monitor) patternPath(r *http.Request) string {
+	pattern := requestPattern(r)
+	if pattern == "" {
+		return UnmatchedAddr
+	}
+	return joinPath(m.addrPrefix, patternPathTemplate(pattern))
+}
+
+// patternPathTemplate returns the path of a ServeMux pattern, without its method, host and end anchor, e.g.
+// /users/{id} for "GET example.com/users/{id}"
+func patternPathTemplate(pattern string) string {
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i:], " \t")
+	}
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		pattern = pattern[i:]
+	}
+	return strings.TrimSuffix(pattern, "{$}")
+}
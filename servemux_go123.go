@@ -0,0 +1,10 @@
+//go:build go1.23
+
+package mux_monitor
+
+import "net/http"
+
+// requestPattern returns the ServeMux pattern matched by the request
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
@@ -0,0 +1,10 @@
+//go:build !go1.23
+
+package mux_monitor
+
+import "net/http"
+
+// requestPattern returns an empty pattern, as ServeMux patterns aren't exposed on requests before Go 1.23
+func requestPattern(*http.Request) string {
+	return ""
+}
@@ -0,0 +1,76 @@
+//go:build go1.23
+
+// the module's go version defaults to the ServeMux of Go 1.21, without patterns
+//go:debug httpmuxgo121=0
+
+package mux_monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labbsr0x/mux-monitor/muxmonitortest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPrometheusServeMux(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+	handler := func(w http.ResponseWriter, _ *http.Request) {}
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("GET /users/{id}", handler)
+	serveMux.HandleFunc("example.com/items/", handler)
+	serveMux.HandleFunc("/{$}", handler)
+	server := monitor.PrometheusServeMux(serveMux)
+
+	for _, target := range []string{"/users/1", "/users/2", "http://example.com/items/3", "/", "/unknown"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	for addr, expected := range map[string]uint64{
+		"/users/{id}": 2,
+		"/items/":     1,
+		"/":           1,
+		UnmatchedAddr: 1,
+	} {
+		if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": addr}); count != expected {
+			t.Errorf("expected %d requests on %s, got %d", expected, addr, count)
+		}
+	}
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": UnmatchedAddr, "status": "404"}); count != 1 {
+		t.Errorf("expected the unmatched request to be recorded as not found, got %d", count)
+	}
+}
+
+func TestPatternPathTemplate(t *testing.T) {
+	for pattern, expected := range map[string]string{
+		"/users/{id}":                 "/users/{id}",
+		"GET /users/{id}":             "/users/{id}",
+		"POST  example.com/users":     "/users",
+		"example.com/":                "/",
+		"/files/{path...}":            "/files/{path...}",
+		"/{$}":                        "/",
+		"DELETE /users/{id}/sessions": "/users/{id}/sessions",
+	} {
+		if path := patternPathTemplate(pattern); path != expected {
+			t.Errorf("expected pattern %q to become %q, got %q", pattern, expected, path)
+		}
+	}
+}
+
+func TestPrometheusServeMuxAddrPrefix(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithAddrPrefix("/api"))
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, _ *http.Request) {})
+	server := http.StripPrefix("/api", monitor.PrometheusServeMux(serveMux))
+
+	for _, target := range []string{"/api/users/1", "/api/users/%FF"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if count := muxmonitortest.SampleCount(t, monitor.reqDuration, prometheus.Labels{"addr": "/api/users/{id}"}); count != 2 {
+		t.Errorf("expected 2 requests on /api/users/{id}, got %d", count)
+	}
+}
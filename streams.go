@@ -0,0 +1,45 @@
+package mux_monitor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// DefaultStreamBuckets are the default buckets of the http2_concurrent_streams histogram
+var DefaultStreamBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128}
+
+type connStreamsKey struct{}
+
+// connStreams counts the requests being served on a connection
+type connStreams struct {
+	active int64
+}
+
+// ConnContext tracks the requests being served on each connection. It must be set as the ConnContext of the
+// http.Server for the http2_concurrent_streams histogram to be recorded.
+func (m *Monitor) ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connStreamsKey{}, &connStreams{})
+}
+
+// startStream counts an HTTP/2 request on its connection, observing how many streams the connection is serving.
+// The returned function must be called when the request is done. HTTP/1 requests and connections not tracked by
+// ConnContext are not observed.
+func (m *Monitor) startStream(r *http.Request, addr string) func() {
+	if m.concurrentStreams == nil || r.ProtoMajor != 2 {
+		return func() {}
+	}
+
+	streams, ok := r.Context().Value(connStreamsKey{}).(*connStreams)
+	if !ok {
+		return func() {}
+	}
+
+	active := atomic.AddInt64(&streams.active, 1)
+	m.concurrentStreams.WithLabelValues(addr).Observe(float64(active))
+
+	return func() {
+		atomic.AddInt64(&streams.active, -1)
+	}
+}
@@ -0,0 +1,63 @@
+package mux_monitor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestWithHTTP2ConcurrentStreams(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithHTTP2ConcurrentStreams(nil))
+	connCtx := monitor.ConnContext(context.Background(), nil)
+
+	newRequest := func(path string, protoMajor int) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(connCtx)
+		req.ProtoMajor = protoMajor
+		return req
+	}
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/inner", func(w http.ResponseWriter, _ *http.Request) {})
+	r.HandleFunc("/outer", func(w http.ResponseWriter, _ *http.Request) {
+		// a second stream served on the same connection while this one is in flight
+		r.ServeHTTP(httptest.NewRecorder(), newRequest("/inner", 2))
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), newRequest("/outer", 2))
+	r.ServeHTTP(httptest.NewRecorder(), newRequest("/inner", 1))
+
+	for addr, expectedSum := range map[string]float64{"/outer": 1, "/inner": 2} {
+		var metric dto.Metric
+		if err := monitor.concurrentStreams.WithLabelValues(addr).(prometheus.Histogram).Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if count := metric.GetHistogram().GetSampleCount(); count != 1 {
+			t.Errorf("expected 1 HTTP/2 observation for %s, got %d", addr, count)
+		}
+		if sum := metric.GetHistogram().GetSampleSum(); sum != expectedSum {
+			t.Errorf("expected %v concurrent streams for %s, got %v", expectedSum, addr, sum)
+		}
+	}
+}
+
+func TestHTTP2ConcurrentStreamsWithoutConnContext(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithHTTP2ConcurrentStreams(nil))
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.ProtoMajor = 2
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if count := sampleCount(t, monitor.concurrentStreams, "/"); count != 0 {
+		t.Errorf("expected no observation for connections not tracked by ConnContext, got %d", count)
+	}
+}
@@ -0,0 +1,116 @@
+package mux_monitor
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// ErrorCount is an error message along with how many times it was recorded
+type ErrorCount struct {
+	Message string
+	Count   uint64
+}
+
+// topErrors approximates the most frequent error messages with the Space-Saving algorithm, keeping at most
+// capacity messages. The count of a message may be overestimated by the count of the message it replaced. The
+// messages are kept in a min-heap by count, so that the least frequent one is replaced in O(log capacity).
+type topErrors struct {
+	mutex    sync.Mutex
+	capacity int
+	counts   map[string]*errorEntry
+	entries  errorHeap
+}
+
+// errorEntry is a tracked error message, at index in the heap
+type errorEntry struct {
+	message string
+	count   uint64
+	index   int
+}
+
+// errorHeap is a heap.Interface of error entries with the least frequent one at its root
+type errorHeap []*errorEntry
+
+func (h errorHeap) Len() int           { return len(h) }
+func (h errorHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h errorHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *errorHeap) Push(x interface{}) {
+	entry := x.(*errorEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *errorHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+func newTopErrors(capacity int) *topErrors {
+	return &topErrors{capacity: capacity, counts: make(map[string]*errorEntry, capacity), entries: make(errorHeap, 0, capacity)}
+}
+
+// add counts an occurrence of the error message, replacing the least frequent message when it's full
+func (t *topErrors) add(message string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if entry, ok := t.counts[message]; ok {
+		entry.count++
+		heap.Fix(&t.entries, entry.index)
+		return
+	}
+
+	if len(t.entries) < t.capacity {
+		entry := &errorEntry{message: message, count: 1}
+		t.counts[message] = entry
+		heap.Push(&t.entries, entry)
+		return
+	}
+
+	least := t.entries[0]
+	delete(t.counts, least.message)
+	least.message = message
+	least.count++
+	t.counts[message] = least
+	heap.Fix(&t.entries, least.index)
+}
+
+// top returns the k most frequent error messages, sorted by descending count
+func (t *topErrors) top(k int) []ErrorCount {
+	t.mutex.Lock()
+	errorCounts := make([]ErrorCount, 0, len(t.entries))
+	for _, entry := range t.entries {
+		errorCounts = append(errorCounts, ErrorCount{Message: entry.message, Count: entry.count})
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(errorCounts, func(i, j int) bool {
+		if errorCounts[i].Count != errorCounts[j].Count {
+			return errorCounts[i].Count > errorCounts[j].Count
+		}
+		return errorCounts[i].Message < errorCounts[j].Message
+	})
+
+	if k < len(errorCounts) {
+		errorCounts = errorCounts[:k]
+	}
+	return errorCounts
+}
+
+// TopErrors returns the k most frequent error messages recorded by the middleware, sorted by descending count.
+// The counts are approximate, and it returns nil unless the monitor was created with WithTopErrors.
+func (m *Monitor) TopErrors(k int) []ErrorCount {
+	if m.topErrors == nil || k <= 0 {
+		return nil
+	}
+	return m.topErrors.top(k)
+}
@@ -0,0 +1,71 @@
+package mux_monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTopErrors(t *testing.T) {
+	monitor, _ := newTestMonitor(t, WithTopErrors(10), WithoutErrorMessageLabel())
+
+	r := mux.NewRouter()
+	r.Use(monitor.Prometheus)
+	r.HandleFunc("/failing", func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(DefaultErrorMessageKey, r.URL.Query().Get("error"))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	for errorMessage, times := range map[string]int{"timeout": 5, "not+found": 3, "conflict": 1, "": 4} {
+		for i := 0; i < times; i++ {
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/failing?error="+errorMessage, nil))
+		}
+	}
+
+	expected := []ErrorCount{{Message: "timeout", Count: 5}, {Message: "not found", Count: 3}}
+	if top := monitor.TopErrors(2); !reflect.DeepEqual(top, expected) {
+		t.Errorf("expected %v, got %v", expected, top)
+	}
+	if top := monitor.TopErrors(10); len(top) != 3 {
+		t.Errorf("expected the 3 recorded error messages, got %v", top)
+	}
+}
+
+func TestTopErrorsBounded(t *testing.T) {
+	top := newTopErrors(2)
+
+	for _, message := range []string{"a", "a", "a", "b", "c", "d", "a"} {
+		top.add(message)
+	}
+
+	if len(top.counts) != 2 {
+		t.Errorf("expected at most 2 tracked messages, got %v", top.counts)
+	}
+	if errorCounts := top.top(1); errorCounts[0] != (ErrorCount{Message: "a", Count: 4}) {
+		t.Errorf("expected the most frequent message to be kept, got %v", errorCounts)
+	}
+}
+
+func TestTopErrorsReplacesLeastFrequent(t *testing.T) {
+	top := newTopErrors(3)
+
+	for _, message := range []string{"a", "b", "a", "c", "b", "a", "d"} {
+		top.add(message)
+	}
+
+	expected := []ErrorCount{{Message: "a", Count: 3}, {Message: "b", Count: 2}, {Message: "d", Count: 2}}
+	if errorCounts := top.top(3); !reflect.DeepEqual(errorCounts, expected) {
+		t.Errorf("expected the least frequent message to be replaced, got %v", errorCounts)
+	}
+}
+
+func TestTopErrorsDisabled(t *testing.T) {
+	monitor, _ := newTestMonitor(t)
+
+	if top := monitor.TopErrors(10); top != nil {
+		t.Errorf("expected no top errors without WithTopErrors, got %v", top)
+	}
+}